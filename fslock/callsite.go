@@ -0,0 +1,48 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// WithCallSite makes the lock save the file and line of the code that
+// acquired it with the held information, so that it can be found by
+// Holder. Finding it makes every acquisition a little more expensive.
+func WithCallSite() Option {
+	return func(lock *Lock) {
+		lock.callSite = true
+	}
+}
+
+// packagePrefix is the prefix of the names of the functions in this
+// package, found at run time in case the package has been vendored.
+var packagePrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	return name[:slash+1+dot+1]
+}()
+
+// callerSite returns the file and line of the first caller outside this
+// package, if the lock was made with WithCallSite.
+func (lock *Lock) callerSite() string {
+	if !lock.callSite {
+		return ""
+	}
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
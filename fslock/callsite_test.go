@@ -0,0 +1,44 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestWithCallSite(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithCallSite())
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockContext(context.Background(), "")
+	c.Assert(err, gc.IsNil)
+	info, err := lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.CallSite, gc.Matches, `.*/callsite_test\.go:19`)
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	info, err = lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.CallSite, gc.Matches, `.*/callsite_test\.go:27`)
+}
+
+func (s *fslockSuite) TestWithoutCallSite(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	info, err := lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.CallSite, gc.Equals, "")
+}
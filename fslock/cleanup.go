@@ -0,0 +1,49 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// orphanName matches the names of the directories left behind in a lock
+// directory by a process that died part way through acquiring,
+// releasing or breaking a lock: the temporary directory a lock is built
+// in, named after the nonce and a random number, and a lock directory
+// renamed out of the way, named after the lock, the nonce and, in newer
+// versions, a random number.
+var orphanName = regexp.MustCompile(`^\.([0-9a-f]{32}[0-9]+|.+\.[0-9a-f]{32}[0-9]*(\.broken)?)$`)
+
+// CleanupOrphans removes the directories left behind in the given lock
+// directory by processes that died while acquiring, releasing or
+// breaking a lock, if they haven't been modified for at least the given
+// duration. Such directories were never, or are no longer, locks, so
+// removing them doesn't affect any lock. It returns the number of
+// directories removed.
+func CleanupOrphans(lockDir string, olderThan time.Duration) (int, error) {
+	entries, err := ioutil.ReadDir(lockDir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !orphanName.MatchString(entry.Name()) {
+			continue
+		}
+		if time.Since(entry.ModTime()) < olderThan {
+			// It may still be in use.
+			continue
+		}
+		logger.Infof("removing orphaned lock directory %q", entry.Name())
+		if err := os.RemoveAll(path.Join(lockDir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestCleanupOrphans(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	const nonce = "0123456789abcdef0123456789abcdef"
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{
+		"." + nonce + "12345",
+		".testing." + nonce,
+		".testing." + nonce + "42",
+		".testing." + nonce + "42.broken",
+		// Guards and queues look after themselves.
+		".testing.guard",
+		".testing.queue",
+	} {
+		err := os.Mkdir(path.Join(dir, name), 0755)
+		c.Assert(err, gc.IsNil)
+		err = os.Chtimes(path.Join(dir, name), old, old)
+		c.Assert(err, gc.IsNil)
+	}
+	// Recent orphans may still be in use.
+	err = os.Mkdir(path.Join(dir, ".other."+nonce), 0755)
+	c.Assert(err, gc.IsNil)
+
+	removed, err := fslock.CleanupOrphans(dir, time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Assert(removed, gc.Equals, 4)
+
+	entries, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	c.Assert(names, gc.DeepEquals, []string{
+		".other." + nonce,
+		".testing.generation",
+		".testing.guard",
+		".testing.queue",
+		"testing",
+	})
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestCleanupOrphansNoDir(c *gc.C) {
+	_, err := fslock.CleanupOrphans(path.Join(c.MkDir(), "missing"), time.Minute)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
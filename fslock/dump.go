@@ -0,0 +1,94 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// DirStatus describes the state of a directory of locks, as reported by
+// Dump.
+type DirStatus struct {
+	// Locks describes each lock in the directory, ordered by name.
+	Locks []LockStatus
+
+	// Orphans holds the names of any directories left behind by
+	// processes that died part way through acquiring, releasing or
+	// breaking a lock, which CleanupOrphans would remove once they
+	// are old enough.
+	Orphans []string
+}
+
+// LockStatus describes the state of a lock on disk, as reported by Dump.
+type LockStatus struct {
+	// Name is the name of the lock.
+	Name string
+
+	// ModTime is the modification time of the lock directory.
+	ModTime time.Time
+
+	// Valid is set when the held information of the lock could be
+	// read, in which case it is described by Holder.
+	Valid bool
+
+	// Holder describes whoever holds the lock, if Valid is set.
+	Holder *LockInfo
+
+	// Readers is the number of holders sharing the lock.
+	Readers int
+
+	// Err holds the error that stopped the lock being fully described,
+	// if there was one; ErrIncompleteLock if it has no held
+	// information.
+	Err error
+}
+
+// Dump describes the state of all the locks in the given lock directory,
+// for diagnosing problems with them. Unlike List, a lock that can't be
+// read doesn't stop the rest being described; the error is reported in
+// its LockStatus instead. An error is only returned if the directory
+// itself can't be read.
+func Dump(lockDir string) (*DirStatus, error) {
+	entries, err := ioutil.ReadDir(lockDir)
+	if err != nil {
+		return nil, err
+	}
+	status := &DirStatus{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if orphanName.MatchString(entry.Name()) {
+			status.Orphans = append(status.Orphans, entry.Name())
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			// Guards, queues and the like look after themselves.
+			continue
+		}
+		lock := &Lock{
+			name:   entry.Name(),
+			parent: lockDir,
+			clock:  wallClock{},
+			fs:     OSFilesystem{},
+		}
+		lockStatus := LockStatus{
+			Name:    entry.Name(),
+			ModTime: entry.ModTime(),
+		}
+		lockStatus.Holder, lockStatus.Err = lock.Holder()
+		if lockStatus.Err == ErrLockNotHeld {
+			// The lock was released after the directory was read.
+			continue
+		}
+		lockStatus.Valid = lockStatus.Err == nil
+		if lockStatus.Valid && lockStatus.Holder.Shared {
+			lockStatus.Readers, lockStatus.Err = lock.ReaderCount()
+		}
+		status.Locks = append(status.Locks, lockStatus)
+	}
+	return status, nil
+}
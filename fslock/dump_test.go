@@ -0,0 +1,69 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"os"
+	"path"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestDump(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "alpha")
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("working")
+	c.Assert(err, gc.IsNil)
+	shared, err := fslock.NewLock(dir, "bravo")
+	c.Assert(err, gc.IsNil)
+	err = shared.LockShared("")
+	c.Assert(err, gc.IsNil)
+	// A lock whose held information is missing, and one where it
+	// can't be read at all.
+	err = os.Mkdir(path.Join(dir, "charlie"), 0755)
+	c.Assert(err, gc.IsNil)
+	err = os.MkdirAll(path.Join(dir, "delta", "held"), 0755)
+	c.Assert(err, gc.IsNil)
+	const orphan = ".alpha.0123456789abcdef0123456789abcdef42"
+	err = os.Mkdir(path.Join(dir, orphan), 0755)
+	c.Assert(err, gc.IsNil)
+
+	status, err := fslock.Dump(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status.Orphans, gc.DeepEquals, []string{orphan})
+	c.Assert(status.Locks, gc.HasLen, 4)
+
+	alpha := status.Locks[0]
+	c.Assert(alpha.Name, gc.Equals, "alpha")
+	c.Assert(alpha.Valid, gc.Equals, true)
+	c.Assert(alpha.Err, gc.IsNil)
+	c.Assert(alpha.Holder.Message, gc.Equals, "working")
+	c.Assert(alpha.Holder.PID, gc.Equals, os.Getpid())
+	c.Assert(alpha.ModTime.IsZero(), gc.Equals, false)
+
+	bravo := status.Locks[1]
+	c.Assert(bravo.Name, gc.Equals, "bravo")
+	c.Assert(bravo.Valid, gc.Equals, true)
+	c.Assert(bravo.Holder.Shared, gc.Equals, true)
+	c.Assert(bravo.Readers, gc.Equals, 1)
+
+	charlie := status.Locks[2]
+	c.Assert(charlie.Name, gc.Equals, "charlie")
+	c.Assert(charlie.Valid, gc.Equals, false)
+	c.Assert(charlie.Holder, gc.IsNil)
+	c.Assert(charlie.Err, gc.Equals, fslock.ErrIncompleteLock)
+
+	delta := status.Locks[3]
+	c.Assert(delta.Name, gc.Equals, "delta")
+	c.Assert(delta.Valid, gc.Equals, false)
+	c.Assert(delta.Err, gc.NotNil)
+}
+
+func (s *fslockSuite) TestDumpNoDir(c *gc.C) {
+	_, err := fslock.Dump(path.Join(c.MkDir(), "missing"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Waiters using LockFair queue up in a directory alongside the lock,
+// each holding a ticket file named after the time it arrived. Only the
+// waiter holding the oldest ticket tries to take the lock. Each waiter
+// rewrites its ticket every time it checks the queue, so that tickets
+// left behind by waiters that went away, on any host, eventually become
+// stale and are removed.
+
+// staleTicketAge is how long a waiter's ticket may go without being
+// rewritten before it is assumed to have been abandoned.
+const staleTicketAge = time.Minute
+
+// ticketInfo is saved in a ticket file, identifying the waiter.
+type ticketInfo struct {
+	PID      int    `json:"pid"`
+	Hostname string `json:"hostname"`
+}
+
+// LockFair blocks until it is able to acquire the lock exclusively,
+// taking turns with any other waiters using LockFair in the order in
+// which they started waiting. Waiters using other methods aren't part of
+// the queue, and may still take the lock in between turns. If the
+// context is done first, the waiter leaves the queue and the context's
+// error is returned. See `Lock` for information about the message.
+//
+// A waiter that goes away without leaving the queue holds up those
+// behind it until its ticket is found to be stale: immediately if it was
+// a process on this host that no longer exists, or else after a minute.
+// For the same reason, the delay between attempts to acquire the lock
+// should be well under a minute. Arrival order across hosts is only as
+// good as the agreement between their clocks.
+func (lock *Lock) LockFair(ctx context.Context, message string) error {
+	ticket, err := lock.joinQueue()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.fs.RemoveAll(ticket); err != nil {
+			logger.Warningf("cannot leave queue for lock %q: %v", lock.name, err)
+		}
+	}()
+	tryExclusive := lock.tryExclusive(message, 0)
+	try := func() (bool, error) {
+		first, err := lock.firstInQueue(ticket)
+		if err != nil || !first {
+			return false, err
+		}
+		return tryExclusive()
+	}
+	continueFunc := func() error { return ctx.Err() }
+	return lock.lockLoop(message, try, continueFunc, ctx.Done())
+}
+
+func (lock *Lock) queueDir() string {
+	return lock.hiddenName("queue")
+}
+
+// joinQueue adds a ticket for the receiver to the end of the queue, and
+// returns its file name.
+func (lock *Lock) joinQueue() (string, error) {
+	if err := lock.fs.MkdirAll(lock.queueDir(), lock.dirMode); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%020d.%x", lock.clock.Now().UnixNano(), lock.nonce)
+	ticket := path.Join(lock.queueDir(), name)
+	if err := lock.writeTicket(ticket); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// writeTicket atomically writes the receiver's ticket to the given file,
+// replacing any older copy of it.
+func (lock *Lock) writeTicket(ticket string) error {
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(ticketInfo{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+	})
+	if err != nil {
+		return err
+	}
+	// Half written tickets have names starting with "." so that they
+	// can be told apart from those in the queue.
+	temp := path.Join(lock.queueDir(), fmt.Sprintf(".%x%d", lock.nonce, rand.Uint32()))
+	if err := lock.fs.WriteFile(temp, data, lock.fileMode); err != nil {
+		return err
+	}
+	return lock.fs.Rename(temp, ticket)
+}
+
+// firstInQueue refreshes the given ticket, removes any stale tickets
+// ahead of it, and reports whether it is now at the front of the queue.
+func (lock *Lock) firstInQueue(ticket string) (bool, error) {
+	// Rewriting the ticket also puts it back if it was removed as stale
+	// while its waiter was between attempts.
+	if err := lock.writeTicket(ticket); err != nil {
+		return false, err
+	}
+	entries, err := lock.fs.ReadDir(lock.queueDir())
+	if err != nil {
+		return false, err
+	}
+	hostname, _ := os.Hostname()
+	for _, entry := range entries {
+		name := path.Join(lock.queueDir(), entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if name == ticket {
+			return true, nil
+		}
+		if !lock.staleTicket(name, entry, hostname) {
+			return false, nil
+		}
+		logger.Infof("removing stale ticket %q for lock %q", entry.Name(), lock.name)
+		if err := lock.fs.RemoveAll(name); err != nil {
+			return false, err
+		}
+	}
+	// Our ticket has gone, even though we just wrote it; leave it to
+	// the next attempt.
+	return false, nil
+}
+
+// staleTicket reports whether the given ticket was left behind by a
+// waiter that has gone away.
+func (lock *Lock) staleTicket(name string, entry os.FileInfo, hostname string) bool {
+	if olderThan(entry, staleTicketAge) {
+		return true
+	}
+	data, err := lock.fs.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	var info ticketInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return false
+	}
+	return info.Hostname == hostname && info.PID > 0 && !processExists(info.PID)
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// queueLen returns the number of complete tickets in the queue for
+// the named lock.
+func queueLen(c *gc.C, dir, name string) int {
+	entries, err := ioutil.ReadDir(path.Join(dir, "."+name+".queue"))
+	if os.IsNotExist(err) {
+		return 0
+	}
+	c.Assert(err, gc.IsNil)
+	n := 0
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForQueueLen waits until the queue for the named lock holds n
+// tickets.
+func waitForQueueLen(c *gc.C, dir, name string, n int) {
+	for timeout := time.After(longWait); queueLen(c, dir, name) != n; {
+		select {
+		case <-timeout:
+			c.Fatalf("Expected %d tickets in the queue", n)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (s *fslockSuite) TestLockFair(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockFair(context.Background(), "fair")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	c.Assert(lock.Message(), gc.Equals, "fair")
+	c.Assert(queueLen(c, dir, "testing"), gc.Equals, 0)
+}
+
+func (s *fslockSuite) TestLockFairArrivalOrder(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	first, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	second, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	firstAcquired := make(chan error, 1)
+	go func() {
+		firstAcquired <- first.LockFair(context.Background(), "")
+	}()
+	waitForQueueLen(c, dir, "testing", 1)
+	secondAcquired := make(chan error, 1)
+	go func() {
+		secondAcquired <- second.LockFair(context.Background(), "")
+	}()
+	waitForQueueLen(c, dir, "testing", 2)
+
+	err = holder.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-firstAcquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	select {
+	case <-secondAcquired:
+		c.Fatalf("Unexpected lock acquisition")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = first.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-secondAcquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(queueLen(c, dir, "testing"), gc.Equals, 0)
+}
+
+func (s *fslockSuite) TestLockFairRemovesStaleTickets(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+
+	// A process that died while waiting left its ticket at the front of
+	// the queue.
+	data, err := json.Marshal(map[string]interface{}{
+		"pid":      deadPID(c),
+		"hostname": hostname,
+	})
+	c.Assert(err, gc.IsNil)
+	queue := path.Join(dir, ".testing.queue")
+	err = os.Mkdir(queue, 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(path.Join(queue, "00000000000000000001.dead"), data, 0644)
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), longWait)
+	defer cancel()
+	err = lock.LockFair(ctx, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(queueLen(c, dir, "testing"), gc.Equals, 0)
+}
+
+func (s *fslockSuite) TestLockFairCancelled(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = lock.LockFair(ctx, "")
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(lock.IsLockHeld(), gc.Equals, false)
+	c.Assert(queueLen(c, dir, "testing"), gc.Equals, 0)
+}
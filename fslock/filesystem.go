@@ -0,0 +1,126 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/utils"
+)
+
+// Filesystem provides the filesystem operations used by a lock, so that
+// they can be replaced; for instance to inject faults in tests, or to
+// keep locks somewhere other than on disk. The errors returned should
+// be those the os package would return in the same situation.
+type Filesystem interface {
+	// Stat returns information about the named file or directory.
+	Stat(name string) (os.FileInfo, error)
+
+	// Mkdir creates the named directory, failing if it already
+	// exists.
+	Mkdir(name string, perm os.FileMode) error
+
+	// MkdirAll creates the named directory along with any missing
+	// parents, succeeding if it already exists.
+	MkdirAll(name string, perm os.FileMode) error
+
+	// Rename atomically moves oldname to newname, replacing newname
+	// if it is a file. It must fail if newname is an existing
+	// directory.
+	Rename(oldname, newname string) error
+
+	// WriteFile writes data to the named file, creating it if
+	// necessary.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+
+	// ReadFile returns the contents of the named file.
+	ReadFile(name string) ([]byte, error)
+
+	// ReadDir returns information about the entries of the named
+	// directory, ordered by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// RemoveAll removes the named file or directory and anything it
+	// contains, succeeding if it doesn't exist.
+	RemoveAll(name string) error
+}
+
+// OSFilesystem is the Filesystem used by locks unless WithFilesystem is
+// used. It works directly on disk, and doesn't return from writing a
+// file or renaming until the change has reached stable storage; a newly
+// written file only has a durable name once it has been renamed, or the
+// directory containing it has.
+type OSFilesystem struct{}
+
+var _ Filesystem = OSFilesystem{}
+
+// Stat implements Filesystem.Stat.
+func (OSFilesystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Mkdir implements Filesystem.Mkdir.
+func (OSFilesystem) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+// MkdirAll implements Filesystem.MkdirAll.
+func (OSFilesystem) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Rename implements Filesystem.Rename. When a directory is renamed, its
+// contents are flushed first, so that it can't appear in its new place
+// without them.
+func (OSFilesystem) Rename(oldname, newname string) error {
+	if info, err := os.Stat(oldname); err == nil && info.IsDir() {
+		if err := syncDir(oldname); err != nil {
+			return err
+		}
+	}
+	if err := utils.ReplaceFile(oldname, newname); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(newname)); err != nil {
+		return err
+	}
+	if filepath.Dir(oldname) == filepath.Dir(newname) {
+		return nil
+	}
+	return syncDir(filepath.Dir(oldname))
+}
+
+// WriteFile implements Filesystem.WriteFile.
+func (OSFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile implements Filesystem.ReadFile.
+func (OSFilesystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+// ReadDir implements Filesystem.ReadDir.
+func (OSFilesystem) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// RemoveAll implements Filesystem.RemoveAll.
+func (OSFilesystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
@@ -0,0 +1,260 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// faultyFilesystem is a fslock.Filesystem that works on disk, but can be
+// made to fail in various ways.
+type faultyFilesystem struct {
+	fslock.OSFilesystem
+
+	// renameErr, if set, is returned instead of renaming.
+	renameErr error
+
+	// renameThenErr, if set, is returned after renaming.
+	renameThenErr error
+
+	// mkdirErr, if set, is returned instead of making a directory.
+	mkdirErr error
+
+	// made records the directories made.
+	made []string
+}
+
+func (fs *faultyFilesystem) Rename(oldname, newname string) error {
+	if fs.renameErr != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.renameErr}
+	}
+	if err := fs.OSFilesystem.Rename(oldname, newname); err != nil {
+		return err
+	}
+	return fs.renameThenErr
+}
+
+func (fs *faultyFilesystem) Mkdir(name string, perm os.FileMode) error {
+	if fs.mkdirErr != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: fs.mkdirErr}
+	}
+	fs.made = append(fs.made, name)
+	return fs.OSFilesystem.Mkdir(name, perm)
+}
+
+// pausingFilesystem is a fslock.Filesystem that works on disk, but
+// pauses the first time it is about to move something to a name with
+// the given suffix, such as a lock being moved aside to break it, until
+// resume is closed.
+type pausingFilesystem struct {
+	fslock.OSFilesystem
+
+	suffix string
+	once   sync.Once
+	paused chan struct{}
+	resume chan struct{}
+}
+
+func newPausingFilesystem(suffix string) *pausingFilesystem {
+	return &pausingFilesystem{
+		suffix: suffix,
+		paused: make(chan struct{}),
+		resume: make(chan struct{}),
+	}
+}
+
+func (fs *pausingFilesystem) Rename(oldname, newname string) error {
+	if strings.HasSuffix(newname, fs.suffix) {
+		fs.once.Do(func() {
+			close(fs.paused)
+			<-fs.resume
+		})
+	}
+	return fs.OSFilesystem.Rename(oldname, newname)
+}
+
+// checkBreakRace checks that two waiters breaking the same lock at once
+// can't both end up believing they hold it. The first waiter is paused
+// just before it breaks the lock, while the second tries to acquire it,
+// using the given function in both cases.
+func checkBreakRace(c *gc.C, dir string, acquire func(*fslock.Lock) error) {
+	fs := newPausingFilesystem(".broken")
+	first, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(fs))
+	c.Assert(err, gc.IsNil)
+	second, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	firstErr := make(chan error, 1)
+	go func() {
+		firstErr <- acquire(first)
+	}()
+	select {
+	case <-fs.paused:
+	case <-time.After(longWait):
+		c.Fatalf("lock never broken")
+	}
+	secondErr := make(chan error, 1)
+	go func() {
+		secondErr <- acquire(second)
+	}()
+	// Give the second waiter the chance to break and take the lock.
+	time.Sleep(shortWait)
+	close(fs.resume)
+
+	acquired := 0
+	for _, w := range []struct {
+		lock *fslock.Lock
+		err  chan error
+	}{{first, firstErr}, {second, secondErr}} {
+		select {
+		case err := <-w.err:
+			if err == nil {
+				acquired++
+				c.Check(w.lock.IsLockHeld(), gc.Equals, true)
+			} else {
+				c.Check(err, gc.Equals, fslock.ErrTimeout)
+			}
+		case <-time.After(longWait):
+			c.Fatalf("waiter never finished")
+		}
+	}
+	c.Assert(acquired, gc.Equals, 1)
+}
+
+// checkRefreshRace checks that a lock isn't broken once its holder has
+// shown it is still alive. The holder takes the lock at a time that
+// makes it breakable, set with its clock, and the breaker is paused
+// just before it claims the lock, with the given suffix, while the
+// holder, its clock now set to the present, calls refresh. The breaker
+// must then give up, failing with expectErr.
+func checkRefreshRace(c *gc.C, suffix string, lockHolder func(*fslock.Lock) error, refresh func(*fslock.Lock) error, acquire func(*fslock.Lock) error, expectErr error) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Now().Add(-time.Hour)}
+	holder, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+	err = lockHolder(holder)
+	c.Assert(err, gc.IsNil)
+
+	fs := newPausingFilesystem(suffix)
+	breaker, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(fs))
+	c.Assert(err, gc.IsNil)
+	breakerErr := make(chan error, 1)
+	go func() {
+		breakerErr <- acquire(breaker)
+	}()
+	select {
+	case <-fs.paused:
+	case <-time.After(longWait):
+		c.Fatalf("lock never claimed")
+	}
+	clock.mu.Lock()
+	clock.now = time.Now()
+	clock.mu.Unlock()
+	err = refresh(holder)
+	close(fs.resume)
+	c.Assert(err, gc.IsNil)
+
+	select {
+	case err := <-breakerErr:
+		c.Assert(err, gc.Equals, expectErr)
+	case <-time.After(longWait):
+		c.Fatalf("breaker never finished")
+	}
+	c.Assert(holder.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestBeatenToRename(c *gc.C) {
+	fs := &faultyFilesystem{renameErr: syscall.EEXIST}
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(fs))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 0)
+
+	fs.renameErr = nil
+	err = lock.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestRenameErrorAfterRename(c *gc.C) {
+	fs := &faultyFilesystem{renameThenErr: errors.New("cannot sync")}
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(fs))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.ErrorMatches, "cannot sync")
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 0)
+}
+
+func (s *fslockSuite) TestMkdirError(c *gc.C) {
+	fs := &faultyFilesystem{mkdirErr: syscall.EACCES}
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(fs))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.ErrorMatches, "mkdir .*: permission denied")
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestOSFilesystemRenameDirectory(c *gc.C) {
+	dir := c.MkDir()
+	fs := fslock.OSFilesystem{}
+	err := fs.Mkdir(path.Join(dir, "one"), 0755)
+	c.Assert(err, gc.IsNil)
+	err = fs.WriteFile(path.Join(dir, "one", "file"), []byte("data"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = fs.Mkdir(path.Join(dir, "two"), 0755)
+	c.Assert(err, gc.IsNil)
+	err = fs.WriteFile(path.Join(dir, "two", "file"), []byte("data"), 0644)
+	c.Assert(err, gc.IsNil)
+
+	// Directories are not replaced.
+	err = fs.Rename(path.Join(dir, "one"), path.Join(dir, "two"))
+	c.Assert(err, gc.NotNil)
+
+	err = fs.Rename(path.Join(dir, "one"), path.Join(dir, "three"))
+	c.Assert(err, gc.IsNil)
+	data, err := fs.ReadFile(path.Join(dir, "three", "file"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "data")
+}
+
+func (s *fslockSuite) TestWithTempDir(c *gc.C) {
+	dir := c.MkDir()
+	tempDir := path.Join(dir, "scratch")
+	fs := &faultyFilesystem{}
+	lock, err := fslock.NewLock(path.Join(dir, "locks"), "testing", fslock.WithFilesystem(fs), fslock.WithTempDir(tempDir))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	c.Assert(fs.made, gc.HasLen, 1)
+	c.Assert(path.Dir(fs.made[0]), gc.Equals, tempDir)
+
+	// The lock directory was moved out of the temporary directory.
+	entries, err := ioutil.ReadDir(tempDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 0)
+}
@@ -0,0 +1,167 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Locker is implemented by both Lock and Flock, so that code taking a
+// lock exclusively can use either without caring which it has.
+type Locker interface {
+	Lock(message string) error
+	Unlock() error
+	IsLockHeld() bool
+}
+
+var (
+	_ Locker = (*Lock)(nil)
+	_ Locker = (*Flock)(nil)
+)
+
+// ErrHeldShared is returned by Flock.Lock when the receiver already
+// holds the lock for shared use. It must be unlocked before it can be
+// acquired exclusively.
+var ErrHeldShared = errors.New("lock already held for shared use")
+
+// Flock is a lock backed by the operating system's own file locking,
+// taken on a file rather than by creating a directory. It is an
+// alternative to Lock with different semantics. The operating system
+// releases the lock when the process holding it exits, however it
+// exits, so it can never be left stale. On the other hand, it is only
+// reliable between processes on a single host, as network filesystems
+// often don't support it faithfully, and nothing is recorded about the
+// holder, so there is no Message, Holder or BreakLock. The methods that
+// acquire it take a message, as Lock's do, so that a Flock can be used
+// wherever a Locker is, but the message isn't saved.
+//
+// Like Lock, a Flock is not reentrant: separate Flock values for the
+// same file exclude each other, even within a process.
+type Flock struct {
+	path string
+
+	// mu guards file, which is open while the lock is held, and
+	// exclusive, which records how it is held.
+	mu        sync.Mutex
+	file      *os.File
+	exclusive bool
+}
+
+// NewFlock returns a new lock on the file at the given path, without
+// acquiring it. The file, and its directory, are created if necessary
+// when the lock is acquired, and are left behind when it is released.
+func NewFlock(path string) (*Flock, error) {
+	return &Flock{path: path}, nil
+}
+
+// Lock blocks until it is able to acquire the lock exclusively. If the
+// receiver already holds the lock exclusively, it does nothing; if it
+// holds it for shared use, ErrHeldShared is returned.
+func (f *Flock) Lock(message string) error {
+	_, err := f.lock(true, true)
+	return err
+}
+
+// TryLock makes a single attempt to acquire the lock exclusively,
+// without waiting, and reports whether it succeeded. As with Lock,
+// ErrHeldShared is returned if the receiver holds the lock for shared
+// use.
+func (f *Flock) TryLock(message string) (bool, error) {
+	return f.lock(true, false)
+}
+
+// LockShared blocks until it is able to acquire the lock for shared
+// use. Any number of holders may share the lock at the same time, but
+// not while it is held exclusively. If the receiver already holds the
+// lock, in either mode, it does nothing.
+func (f *Flock) LockShared(message string) error {
+	_, err := f.lock(false, true)
+	return err
+}
+
+// TryLockShared makes a single attempt to acquire the lock for shared
+// use, without waiting, and reports whether it succeeded.
+func (f *Flock) TryLockShared(message string) (bool, error) {
+	return f.lock(false, false)
+}
+
+// lock acquires the lock, exclusively or for shared use, waiting for it
+// if wait is true. The mutex isn't held while waiting, so that the
+// receiver can still be used from other goroutines meanwhile.
+func (f *Flock) lock(exclusive, wait bool) (bool, error) {
+	if held, err := f.checkHeld(exclusive); held || err != nil {
+		return held, err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	acquired, err := lockFile(file, exclusive, wait)
+	if err != nil || !acquired {
+		file.Close()
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		// Another goroutine acquired the lock for the receiver while
+		// this one was waiting, and still holds it.
+		unlockFile(file)
+		file.Close()
+		if exclusive && !f.exclusive {
+			return false, ErrHeldShared
+		}
+		return true, nil
+	}
+	f.file = file
+	f.exclusive = exclusive
+	return true, nil
+}
+
+// checkHeld reports whether the receiver already holds the lock in a
+// mode that satisfies a request for it, exclusively or not. Holding it
+// exclusively satisfies both, but holding it for shared use doesn't
+// satisfy a request for it exclusively, so ErrHeldShared is returned.
+func (f *Flock) checkHeld(exclusive bool) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return false, nil
+	}
+	if exclusive && !f.exclusive {
+		return false, ErrHeldShared
+	}
+	return true, nil
+}
+
+// Unlock releases the lock. If the receiver doesn't hold the lock,
+// ErrLockNotHeld is returned.
+func (f *Flock) Unlock() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return ErrLockNotHeld
+	}
+	err := unlockFile(f.file)
+	// Closing the file releases the lock anyway.
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
+
+// IsLockHeld returns whether the lock is currently held by the
+// receiver.
+func (f *Flock) IsLockHeld() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file != nil
+}
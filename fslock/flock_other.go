@@ -0,0 +1,26 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build !windows,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package fslock
+
+import (
+	"errors"
+	"os"
+)
+
+// errFlockNotSupported is returned when trying to acquire a Flock on a
+// platform without a file locking backend.
+var errFlockNotSupported = errors.New("file locking not supported on this platform")
+
+// lockFile locks the given file, exclusively or for shared use, waiting
+// if wait is true, and reports whether it did so.
+func lockFile(file *os.File, exclusive, wait bool) (bool, error) {
+	return false, errFlockNotSupported
+}
+
+// unlockFile unlocks the given file, locked by lockFile.
+func unlockFile(file *os.File) error {
+	return errFlockNotSupported
+}
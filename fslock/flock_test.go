@@ -0,0 +1,227 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestFlock(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "locks", "testing")
+	lock1, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, false)
+
+	acquired, err := lock2.TryLock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, false)
+	acquired, err = lock2.TryLock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+}
+
+func (s *fslockSuite) TestFlockBlocks(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "testing")
+	lock1, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	select {
+	case <-acquired:
+		c.Fatalf("Unexpected lock acquisition")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestFlockUsableWhileWaiting(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "testing")
+	lock1, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	time.Sleep(shortWait)
+
+	// The waiting Lock must not stop the lock being inspected.
+	checked := make(chan error, 1)
+	go func() {
+		if lock2.IsLockHeld() {
+			checked <- fmt.Errorf("lock held while waiting")
+			return
+		}
+		checked <- lock2.Unlock()
+	}()
+	select {
+	case err := <-checked:
+		c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	case <-time.After(longWait):
+		c.Fatalf("Flock blocked while waiting for the lock")
+	}
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestFlockCreatesDirectoryOnAcquire(c *gc.C) {
+	dir := filepath.Join(c.MkDir(), "locks")
+	lock, err := fslock.NewFlock(filepath.Join(dir, "testing"))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(dir)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(dir)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestFlockLockHeldShared(c *gc.C) {
+	lock, err := fslock.NewFlock(filepath.Join(c.MkDir(), "testing"))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.Equals, fslock.ErrHeldShared)
+	acquired, err := lock.TryLock("")
+	c.Assert(err, gc.Equals, fslock.ErrHeldShared)
+	c.Assert(acquired, gc.Equals, false)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+
+	// Holding the lock exclusively satisfies a request to share it.
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestFlockUnlockNotHeld(c *gc.C) {
+	lock, err := fslock.NewFlock(filepath.Join(c.MkDir(), "testing"))
+	c.Assert(err, gc.IsNil)
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestFlockShared(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "testing")
+	reader1, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+	writer, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	acquired, err := reader2.TryLockShared("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+	acquired, err = writer.TryLock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+
+	err = reader1.Unlock()
+	c.Assert(err, gc.IsNil)
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	acquired, err = writer.TryLock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+	acquired, err = reader1.TryLockShared("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+}
+
+func (s *fslockSuite) TestFlockReleasedOnExit(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("no shell to hold the lock with on windows")
+	}
+	if _, err := exec.LookPath("flock"); err != nil {
+		c.Skip("flock command not available")
+	}
+	path := filepath.Join(c.MkDir(), "testing")
+	lock, err := fslock.NewFlock(path)
+	c.Assert(err, gc.IsNil)
+
+	// Another process takes the lock, and is killed while holding it.
+	// With -o, the command run doesn't inherit the lock.
+	cmd := exec.Command("flock", "-o", path, "sleep", "10")
+	err = cmd.Start()
+	c.Assert(err, gc.IsNil)
+	for timeout := time.After(longWait); ; {
+		acquired, err := lock.TryLock("")
+		c.Assert(err, gc.IsNil)
+		if !acquired {
+			break
+		}
+		err = lock.Unlock()
+		c.Assert(err, gc.IsNil)
+		select {
+		case <-timeout:
+			c.Fatalf("Lock not taken by other process")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	err = cmd.Process.Kill()
+	c.Assert(err, gc.IsNil)
+	cmd.Wait()
+
+	acquired, err := lock.TryLock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+}
@@ -0,0 +1,44 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package fslock
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks the given file, exclusively or for shared use, waiting
+// if wait is true, and reports whether it did so.
+func lockFile(file *os.File, exclusive, wait bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		switch err {
+		case nil:
+			return true, nil
+		case syscall.EINTR:
+			// Interrupted by a signal while waiting; try again.
+			continue
+		case syscall.EWOULDBLOCK:
+			return false, nil
+		}
+		return false, &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+	}
+}
+
+// unlockFile unlocks the given file, locked by lockFile.
+func unlockFile(file *os.File) error {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN); err != nil {
+		return &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+	}
+	return nil
+}
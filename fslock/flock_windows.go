@@ -0,0 +1,54 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build windows
+
+package fslock
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+const (
+	lockfile_fail_immediately = 0x1
+	lockfile_exclusive_lock   = 0x2
+
+	// error_lock_violation is returned by LockFileEx when the lock is
+	// held by someone else and LOCKFILE_FAIL_IMMEDIATELY is set.
+	error_lock_violation = syscall.Errno(33)
+)
+
+//sys lockFileEx(handle syscall.Handle, flags uint32, reserved uint32, bytesLow uint32, bytesHigh uint32, overlapped *syscall.Overlapped) (err error) = LockFileEx
+//sys unlockFileEx(handle syscall.Handle, reserved uint32, bytesLow uint32, bytesHigh uint32, overlapped *syscall.Overlapped) (err error) = UnlockFileEx
+
+// lockFile locks the whole of the given file, exclusively or for shared
+// use, waiting if wait is true, and reports whether it did so.
+func lockFile(file *os.File, exclusive, wait bool) (bool, error) {
+	var flags uint32
+	if exclusive {
+		flags |= lockfile_exclusive_lock
+	}
+	if !wait {
+		flags |= lockfile_fail_immediately
+	}
+	// see https://msdn.microsoft.com/en-us/library/windows/desktop/aa365203(v=vs.85).aspx
+	err := lockFileEx(syscall.Handle(file.Fd()), flags, 0, math.MaxUint32, math.MaxUint32, &syscall.Overlapped{})
+	if err == error_lock_violation {
+		return false, nil
+	}
+	if err != nil {
+		return false, &os.PathError{Op: "lock", Path: file.Name(), Err: err}
+	}
+	return true, nil
+}
+
+// unlockFile unlocks the given file, locked by lockFile.
+func unlockFile(file *os.File) error {
+	err := unlockFileEx(syscall.Handle(file.Fd()), 0, math.MaxUint32, math.MaxUint32, &syscall.Overlapped{})
+	if err != nil {
+		return &os.PathError{Op: "unlock", Path: file.Name(), Err: err}
+	}
+	return nil
+}
@@ -12,12 +12,18 @@ package fslock
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
+	"math/rand"
 	"os"
 	"path"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/juju/loggo"
@@ -27,67 +33,656 @@ import (
 
 const (
 	// NameRegexp specifies the regular expression used to identify valid lock names.
-	NameRegexp      = "^[a-z]+[a-z0-9.-]*$"
-	heldFilename    = "held"
-	messageFilename = "message"
+	NameRegexp = "^[a-z]+[a-z0-9.-]*$"
+
+	// RelaxedNameRegexp specifies the regular expression used by
+	// RelaxedNames to identify valid lock names.
+	RelaxedNameRegexp = "^[A-Za-z0-9_][A-Za-z0-9_.-]*$"
+
+	defaultHeldFilename = "held"
+	messageFilename     = "message"
+	readersFilename     = "readers"
+
+	// incompleteLockAge is how long a lock may be incomplete before it
+	// is assumed to have been abandoned, and broken by those waiting to
+	// acquire it.
+	incompleteLockAge = 10 * time.Second
+
+	// nonceLength is the length of the nonces read from a source set
+	// with WithNonceSource, which is the same as that of a UUID.
+	nonceLength = 16
 )
 
 var (
-	logger         = loggo.GetLogger("juju.utils.fslock")
+	logger = loggo.GetLogger("juju.utils.fslock")
+
+	// ErrLockNotHeld is returned when the lock isn't held, or isn't
+	// held by the receiver when that is required.
 	ErrLockNotHeld = errors.New("lock not held")
-	ErrTimeout     = errors.New("lock timeout exceeded")
 
-	validName = regexp.MustCompile(NameRegexp)
+	// ErrTimeout is returned by LockWithTimeout, and the other methods
+	// that wait for a limited time, when the lock couldn't be acquired
+	// in time.
+	ErrTimeout = errors.New("lock timeout exceeded")
+
+	// ErrLockGone is returned by Unlock when the receiver acquired the
+	// lock, but the directory containing it has since been removed,
+	// taking the lock with it. ErrLockNotHeld is returned instead if
+	// the receiver never held the lock, or it was broken.
+	ErrLockGone = errors.New("lock removed along with its directory")
+
+	// ErrIncompleteLock is returned when the lock directory exists but
+	// doesn't contain valid held information, for instance because the
+	// holder is part way through taking the lock. It is worth retrying
+	// the operation after a short delay.
+	ErrIncompleteLock = errors.New("lock held information not available")
+
+	// ErrInvalidName is matched, using errors.Is, by the
+	// *InvalidNameError returned by NewLock when given a name that
+	// isn't valid.
+	ErrInvalidName = errors.New("invalid lock name")
+
+	validName   = regexp.MustCompile(NameRegexp)
+	relaxedName = regexp.MustCompile(RelaxedNameRegexp)
 
 	LockWaitDelay = 1 * time.Second
 )
 
+// InvalidNameError is returned by NewLock when given a name that isn't
+// valid.
+type InvalidNameError struct {
+	// Name holds the name given.
+	Name string
+
+	// Err holds the error returned by the name validator set with
+	// WithNameValidator, if that is what rejected the name.
+	Err error
+
+	// pattern holds the regular expression the name failed to match,
+	// if the default policy rejected it.
+	pattern string
+}
+
+// Error implements error.
+func (e *InvalidNameError) Error() string {
+	switch {
+	case e.pattern != "":
+		return fmt.Sprintf("Invalid lock name %q.  Names must match %q", e.Name, e.pattern)
+	case e.Err != nil:
+		return fmt.Sprintf("Invalid lock name %q: %v", e.Name, e.Err)
+	}
+	return fmt.Sprintf("Invalid lock name %q", e.Name)
+}
+
+// Unwrap returns the error returned by the name validator, if any.
+func (e *InvalidNameError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrInvalidName.
+func (e *InvalidNameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+// LockInfo holds information about whoever holds a lock.
+type LockInfo struct {
+	// Name is the name of the lock.
+	Name string
+
+	// Incomplete is set when the lock exists but the information about
+	// its holder can't be read yet. Only List reports such locks; the
+	// remaining fields are then zero.
+	Incomplete bool
+
+	// Shared is set when the lock is held for shared use, in which case
+	// the remaining fields describe the first holder to take it.
+	Shared bool
+
+	// PID is the process id of the holder.
+	PID int
+
+	// Hostname is the name of the machine the holder is running on.
+	Hostname string
+
+	// AcquiredAt is the time the lock was acquired.
+	AcquiredAt time.Time
+
+	// Message is the message saved when the lock was acquired.
+	Message string
+
+	// Heartbeat is the time the holder last showed it was alive with
+	// Touch, or AcquiredAt if it never has.
+	Heartbeat time.Time
+
+	// CallSite is the file and line from which the lock was acquired,
+	// if the holder used WithCallSite.
+	CallSite string
+}
+
+// heldInfo is the information saved in the held file of a lock.
+type heldInfo struct {
+	Nonce      []byte     `json:"nonce"`
+	PID        int        `json:"pid"`
+	Hostname   string     `json:"hostname"`
+	AcquiredAt time.Time  `json:"acquired-at"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Identity   string     `json:"identity,omitempty"`
+	Heartbeat  *time.Time `json:"heartbeat,omitempty"`
+	Generation uint64     `json:"generation,omitempty"`
+	CallSite   string     `json:"call-site,omitempty"`
+
+	// Shared is set when the lock is held for shared use. The holders
+	// are then recorded in the readers file, and the rest of the held
+	// information describes whoever first took the shared lock.
+	Shared bool `json:"shared,omitempty"`
+}
+
+// Lock is a lock on a named resource, shared between processes by
+// creating a directory named after it. A Lock is safe for concurrent use
+// by multiple goroutines. It is the Lock that holds the lock, not the
+// goroutine that acquired it, so goroutines using the same Lock hold it
+// together: one may release the lock taken by another, and they
+// don't exclude each other when taking it for shared use or with
+// WithReentrancy.
 type Lock struct {
-	name   string
-	parent string
-	nonce  []byte
+	name       string
+	parent     string
+	nonce      []byte
+	retryDelay time.Duration
+	retry      RetryStrategy
+	jitter     time.Duration
+	reentrant  bool
+	clock      Clock
+	fs         Filesystem
+	watcher    Watcher
+	observer   Observer
+	log        Logger
+	dirMode    os.FileMode
+	fileMode   os.FileMode
+	validate   func(name string) error
+	tempParent string
+	identity   string
+	watchdog   time.Duration
+	source     io.Reader
+	callSite   bool
+	noCreate   bool
+	heldName   string
+
+	// mu guards depth, which counts how many times a reentrant lock
+	// has been acquired by the receiver without being unlocked;
+	// holding, which is set while the receiver believes it holds the
+	// lock; and watchdogStop, which stops the watchdog while it is
+	// running.
+	mu           sync.Mutex
+	depth        int
+	holding      bool
+	watchdogStop chan struct{}
+
+	// generationMu guards generation, the highest generation of the
+	// lock the receiver has seen. It is separate from mu, which is
+	// held while acquiring a reentrant lock.
+	generationMu sync.Mutex
+	generation   uint64
+}
+
+// RetryStrategy decides how long a lock waits between attempts to
+// acquire it, and when to stop trying.
+type RetryStrategy interface {
+	// NextDelay returns how long to wait after the given number of
+	// failed attempts, counting from zero, and whether to try again at
+	// all. The attempt count starts again from zero each time the lock
+	// is waited for.
+	NextDelay(attempt int) (time.Duration, bool)
+}
+
+// ConstantDelay is a RetryStrategy that always waits for the same time,
+// and never stops trying.
+type ConstantDelay time.Duration
+
+// NextDelay implements RetryStrategy.NextDelay.
+func (d ConstantDelay) NextDelay(attempt int) (time.Duration, bool) {
+	return time.Duration(d), true
+}
+
+// Backoff describes an exponentially increasing delay between attempts
+// to acquire a lock. It is a RetryStrategy that never stops trying.
+type Backoff struct {
+	// Initial is the delay after the first failed attempt.
+	Initial time.Duration
+
+	// Max is the longest delay between attempts. If it is zero, the
+	// delay is not limited.
+	Max time.Duration
+
+	// Factor is the amount the delay is multiplied by after each
+	// failed attempt. Factors less than one are treated as one.
+	Factor float64
+
+	// Jitter is the fraction of each delay, between zero and one, that
+	// is randomised so that waiters don't retry in lockstep.
+	Jitter float64
+}
+
+// Delay returns how long to wait after the given number of failed
+// attempts, counting from zero.
+func (b Backoff) Delay(attempt int) time.Duration {
+	factor := math.Max(b.Factor, 1)
+	delay := float64(b.Initial) * math.Pow(factor, float64(attempt))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if delay > math.MaxInt64 {
+		delay = math.MaxInt64
+	}
+	jitter := math.Min(math.Max(b.Jitter, 0), 1)
+	delay -= delay * jitter * rand.Float64()
+	return time.Duration(delay)
+}
+
+// NextDelay implements RetryStrategy.NextDelay.
+func (b Backoff) NextDelay(attempt int) (time.Duration, bool) {
+	return b.Delay(attempt), true
+}
+
+// Clock provides the current time and the means to wait, so that the
+// passage of time seen by a lock can be controlled in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the
+	// current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// wallClock is the Clock used by locks unless WithClock is used.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Option configures a Lock created by NewLock.
+type Option func(*Lock)
+
+// WithRetryDelay sets how long the lock waits between attempts to
+// acquire it. If it isn't set, LockWaitDelay is used.
+func WithRetryDelay(delay time.Duration) Option {
+	return func(lock *Lock) {
+		lock.retryDelay = delay
+	}
+}
+
+// WithClock makes the lock use the given clock for all timing, instead
+// of the system clock.
+func WithClock(clock Clock) Option {
+	return func(lock *Lock) {
+		lock.clock = clock
+	}
+}
+
+// WithFilesystem makes the lock use the given filesystem, instead of
+// working directly on disk.
+func WithFilesystem(fs Filesystem) Option {
+	return func(lock *Lock) {
+		lock.fs = fs
+	}
+}
+
+// WithBackoff makes the lock wait for an exponentially increasing delay
+// between attempts to acquire it, starting again from the initial delay
+// each time the lock is acquired. It overrides WithRetryDelay. Delays
+// shorter than a millisecond, as given by a zero Backoff, are treated as
+// a millisecond.
+func WithBackoff(backoff Backoff) Option {
+	return func(lock *Lock) {
+		lock.retry = backoff
+	}
+}
+
+// WithRetryStrategy makes the lock use the given strategy to decide how
+// long to wait between attempts to acquire it. When the strategy says to
+// stop trying, the lock gives up with ErrTimeout, whichever method is
+// waiting for it; methods reporting whether they acquired the lock
+// report that they didn't. It overrides WithRetryDelay, and replaces
+// WithBackoff. As with WithBackoff, delays shorter than a millisecond
+// are treated as a millisecond.
+func WithRetryStrategy(strategy RetryStrategy) Option {
+	return func(lock *Lock) {
+		lock.retry = strategy
+	}
+}
+
+// WithInitialJitter makes the lock wait for a random time, up to the
+// given maximum, after the first failed attempt to acquire it, rather
+// than the usual delay, so that waiters that started together don't
+// retry in lockstep. Later attempts are made with the usual delay.
+func WithInitialJitter(max time.Duration) Option {
+	return func(lock *Lock) {
+		lock.jitter = max
+	}
+}
+
+// WithReentrancy makes a lock that is held exclusively by the receiver
+// count repeated acquisitions, rather than blocking on itself. The lock
+// is only released once it has been unlocked as many times as it was
+// acquired. This only affects the receiver: other Lock values for the
+// same lock, even in the same process, still block.
+func WithReentrancy() Option {
+	return func(lock *Lock) {
+		lock.reentrant = true
+	}
+}
+
+// WithDirMode sets the permissions, before the umask, of the
+// directories the lock creates: the lock directory itself, and its
+// parent if that doesn't exist. If it isn't set, 0755 is used.
+func WithDirMode(mode os.FileMode) Option {
+	return func(lock *Lock) {
+		lock.dirMode = mode
+	}
+}
+
+// WithFileMode sets the permissions, before the umask, of the files the
+// lock writes. If it isn't set, 0644 is used.
+func WithFileMode(mode os.FileMode) Option {
+	return func(lock *Lock) {
+		lock.fileMode = mode
+	}
+}
+
+// WithNameValidator makes NewLock check the lock name with the given
+// function, which returns an error describing why a name isn't valid,
+// instead of requiring it to match NameRegexp. Whatever the validator
+// says, names can't be empty, start with ".", or contain a path
+// separator. Bear in mind that names differing only in case are the same
+// lock on a case insensitive filesystem.
+func WithNameValidator(validate func(name string) error) Option {
+	return func(lock *Lock) {
+		lock.validate = validate
+	}
+}
+
+// RelaxedNames is a name validator, for use with WithNameValidator,
+// which allows upper case letters, underscores and a leading digit as
+// well as the names allowed by default. Names must match the regular
+// expression defined by RelaxedNameRegexp.
+func RelaxedNames(name string) error {
+	if !relaxedName.MatchString(name) {
+		return fmt.Errorf("names must match %q", RelaxedNameRegexp)
+	}
+	return nil
+}
+
+// WithTempDir makes the lock build its lock directory in the given
+// directory before moving it into place, rather than in the lock's
+// parent directory. The directory is created if necessary, and NewLock
+// fails if it isn't on the same filesystem as the parent, as the lock
+// directory couldn't then be moved into place atomically.
+func WithTempDir(dir string) Option {
+	return func(lock *Lock) {
+		lock.tempParent = dir
+	}
+}
+
+// WithIdentity saves the given identity with the held information
+// whenever the lock is taken. The identity should stay the same across
+// restarts of whatever uses the lock, so that once restarted it can take
+// back a lock it held before with Recover, rather than having to break
+// it.
+func WithIdentity(identity string) Option {
+	return func(lock *Lock) {
+		lock.identity = identity
+	}
+}
+
+// WithNonceSource makes the lock read the nonce that identifies it from
+// the given source, instead of generating a random UUID. Locks with the
+// same nonce can't be told apart, so they hold the lock together, and
+// anything but a cryptographically secure random number generator is
+// only really suitable for tests.
+func WithNonceSource(source io.Reader) Option {
+	return func(lock *Lock) {
+		lock.source = source
+	}
+}
+
+// WithoutParentCreate stops NewLock creating the lock's parent directory
+// if it doesn't exist, or the directory set by WithTempDir. Attempts to
+// acquire the lock then fail, with an error satisfying os.IsNotExist,
+// while the parent directory is missing. With WithTempDir, NewLock still
+// needs both directories to exist, to check they are on the same
+// filesystem.
+func WithoutParentCreate() Option {
+	return func(lock *Lock) {
+		lock.noCreate = true
+	}
+}
+
+// WithHeldFilename sets the name of the file, inside the lock directory,
+// that holds the information about whoever holds the lock. If it isn't
+// set, "held" is used. Every Lock using the same lock has to agree on
+// the name; List and Dump only understand locks using the default. The
+// name can't start with ".", contain a path separator, or be one of the
+// other names used inside the lock directory.
+func WithHeldFilename(name string) Option {
+	return func(lock *Lock) {
+		lock.heldName = name
+	}
 }
 
 // NewLock returns a new lock with the given name within the given lock
 // directory, without acquiring it. The lock name must match the regular
-// expression defined by NameRegexp.
-func NewLock(lockDir, name string) (*Lock, error) {
-	if !validName.MatchString(name) {
-		return nil, fmt.Errorf("Invalid lock name %q.  Names must match %q", name, NameRegexp)
+// expression defined by NameRegexp, unless WithNameValidator is used.
+// The lock is configured by applying the given options in order.
+func NewLock(lockDir, name string, options ...Option) (*Lock, error) {
+	lock := &Lock{
+		name:     name,
+		parent:   lockDir,
+		clock:    wallClock{},
+		fs:       OSFilesystem{},
+		observer: nopObserver{},
+		dirMode:  0755,
+		fileMode: 0644,
 	}
-	nonce, err := utils.NewUUID()
+	for _, option := range options {
+		option(lock)
+	}
+	nonce, err := lock.newNonce()
 	if err != nil {
 		return nil, err
 	}
-	lock := &Lock{
-		name:   name,
-		parent: lockDir,
-		nonce:  nonce[:],
+	lock.nonce = nonce
+	if err := lock.validateName(); err != nil {
+		return nil, err
 	}
-	// Ensure the parent exists.
-	if err := os.MkdirAll(lock.parent, 0755); err != nil {
+	if err := lock.validateHeldName(); err != nil {
 		return nil, err
 	}
+	// Ensure the parent exists.
+	if !lock.noCreate {
+		if err := lock.fs.MkdirAll(lock.parent, lock.dirMode); err != nil {
+			return nil, err
+		}
+	}
+	if lock.tempParent != "" {
+		if err := lock.checkTempParent(); err != nil {
+			return nil, err
+		}
+	}
 	return lock, nil
 }
 
+// newNonce returns a nonce read from the lock's nonce source, or a new
+// random UUID if it doesn't have one.
+func (lock *Lock) newNonce() ([]byte, error) {
+	if lock.source == nil {
+		nonce, err := utils.NewUUID()
+		if err != nil {
+			return nil, err
+		}
+		return nonce[:], nil
+	}
+	nonce := make([]byte, nonceLength)
+	if _, err := io.ReadFull(lock.source, nonce); err != nil {
+		return nil, fmt.Errorf("cannot read lock nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+// checkTempParent ensures that the directory set by WithTempDir exists,
+// creating it unless WithoutParentCreate was used, and is on the same
+// filesystem as the lock's parent directory.
+func (lock *Lock) checkTempParent() error {
+	if !lock.noCreate {
+		if err := lock.fs.MkdirAll(lock.tempParent, lock.dirMode); err != nil {
+			return err
+		}
+	}
+	tempInfo, err := lock.fs.Stat(lock.tempParent)
+	if err != nil {
+		return err
+	}
+	parentInfo, err := lock.fs.Stat(lock.parent)
+	if err != nil {
+		return err
+	}
+	if !sameFilesystem(lock.tempParent, lock.parent, tempInfo, parentInfo) {
+		return fmt.Errorf("temporary directory %q is not on the same filesystem as lock directory %q", lock.tempParent, lock.parent)
+	}
+	return nil
+}
+
+// validateName checks the lock name against the lock's name validator,
+// returning an *InvalidNameError if it isn't valid.
+func (lock *Lock) validateName() error {
+	if lock.validate == nil {
+		if !validName.MatchString(lock.name) {
+			return &InvalidNameError{Name: lock.name, pattern: NameRegexp}
+		}
+		return nil
+	}
+	// Names starting with "." are kept for the files and directories
+	// alongside the locks, and no name may reach outside the lock
+	// directory.
+	if lock.name == "" || strings.HasPrefix(lock.name, ".") || strings.ContainsAny(lock.name, `/\`) {
+		return &InvalidNameError{Name: lock.name}
+	}
+	if err := lock.validate(lock.name); err != nil {
+		return &InvalidNameError{Name: lock.name, Err: err}
+	}
+	return nil
+}
+
+// Name returns the name of the lock.
+func (lock *Lock) Name() string {
+	return lock.name
+}
+
+// Dir returns the directory containing the lock, as given to NewLock.
+func (lock *Lock) Dir() string {
+	return lock.parent
+}
+
+// Equal reports whether the receiver and other refer to the same lock:
+// one with the same name in the same directory. Unlike IsLockHeld, it
+// doesn't matter whether they share a nonce; two locks that are Equal
+// still exclude each other.
+func (lock *Lock) Equal(other *Lock) bool {
+	if other == nil {
+		return false
+	}
+	return lock.lockDir() == other.lockDir()
+}
+
+// validateHeldName checks the name set by WithHeldFilename, if any.
+func (lock *Lock) validateHeldName() error {
+	if lock.heldName == "" {
+		return nil
+	}
+	name := lock.heldName
+	// Names starting with "." are kept for the temporary files in the
+	// lock directory.
+	if strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) || name == messageFilename || name == readersFilename {
+		return fmt.Errorf("invalid held filename %q", name)
+	}
+	return nil
+}
+
 func (lock *Lock) lockDir() string {
 	return path.Join(lock.parent, lock.name)
 }
 
+// hiddenName returns the path of a file alongside the lock directory,
+// named after the lock with the given suffix. The name starts with "."
+// so it can't be a valid lock name.
+func (lock *Lock) hiddenName(suffix string) string {
+	return path.Join(lock.parent, "."+lock.name+"."+suffix)
+}
+
+// olderThan reports whether the file described by info was last
+// modified more than d ago. The modification time is set by the
+// filesystem, so it is compared with the system clock rather than the
+// lock's.
+func olderThan(info os.FileInfo, d time.Duration) bool {
+	return time.Since(info.ModTime()) > d
+}
+
+// heldFilename returns the name of the held file within the lock
+// directory.
+func (lock *Lock) heldFilename() string {
+	if lock.heldName == "" {
+		return defaultHeldFilename
+	}
+	return lock.heldName
+}
+
 func (lock *Lock) heldFile() string {
-	return path.Join(lock.lockDir(), "held")
+	return path.Join(lock.lockDir(), lock.heldFilename())
 }
 
+// messageFile returns the name of the file in which versions of this
+// package that didn't save the message in the held file saved it.
 func (lock *Lock) messageFile() string {
-	return path.Join(lock.lockDir(), "message")
+	return path.Join(lock.lockDir(), messageFilename)
 }
 
-// If message is set, it will write the message to the lock directory as the
-// lock is taken.
-func (lock *Lock) acquire(message string) (bool, error) {
+// acquire makes a single attempt to acquire the lock exclusively. If
+// message is set, it is saved with the held information as the lock is
+// taken. If ttl is non-zero, the lock is taken as a lease which
+// expires after that duration.
+func (lock *Lock) acquire(message string, ttl time.Duration) (bool, error) {
+	info := lock.newHeldInfo()
+	info.Message = message
+	if ttl > 0 {
+		expires := lock.clock.Now().UTC().Add(ttl)
+		info.Expires = &expires
+	}
+	return lock.acquireWith(info)
+}
+
+// newHeldInfo returns the held information describing the receiver
+// taking the lock now.
+func (lock *Lock) newHeldInfo() *heldInfo {
+	// Failing to find the hostname only makes the information less
+	// useful, so don't let it stop us taking the lock.
+	hostname, _ := os.Hostname()
+	return &heldInfo{
+		Nonce:      lock.nonce,
+		PID:        os.Getpid(),
+		Hostname:   hostname,
+		AcquiredAt: lock.clock.Now().UTC().Truncate(time.Second),
+		Identity:   lock.identity,
+		CallSite:   lock.callerSite(),
+	}
+}
+
+// acquireWith makes a single attempt to acquire the lock, saving the
+// given held information with it. If the information says the lock is
+// shared, the receiver is recorded as its only reader.
+func (lock *Lock) acquireWith(info *heldInfo) (bool, error) {
 	// If the lockDir exists, then the lock is held by someone else.
-	_, err := os.Stat(lock.lockDir())
+	_, err := lock.fs.Stat(lock.lockDir())
 	if err == nil {
 		return false, nil
 	}
@@ -96,49 +691,150 @@ func (lock *Lock) acquire(message string) (bool, error) {
 	}
 	// Create a temporary directory (in the parent dir), and then move it to
 	// the right name.  Using the same directory to make sure the directories
-	// are on the same filesystem.  Use a directory name starting with "." as
-	// it isn't a valid lock name.
-	tempLockName := fmt.Sprintf(".%x", lock.nonce)
-	tempDirName, err := ioutil.TempDir(lock.parent, tempLockName)
+	// are on the same filesystem.
+	tempDirName, err := lock.tempDir()
 	if err != nil {
 		return false, err // this shouldn't really fail...
 	}
+	info.Generation = lock.nextGeneration()
+	// Unless the temp directory becomes the lock directory, make sure it
+	// doesn't get left behind.
+	acquired := false
+	defer func() {
+		if !acquired {
+			lock.fs.RemoveAll(tempDirName)
+		}
+	}()
 	// write nonce into the temp dir
-	err = ioutil.WriteFile(path.Join(tempDirName, heldFilename), lock.nonce, 0755)
+	held, err := json.Marshal(info)
 	if err != nil {
 		return false, err
 	}
-	if message != "" {
-		err = ioutil.WriteFile(path.Join(tempDirName, messageFilename), []byte(message), 0755)
+	err = lock.fs.WriteFile(path.Join(tempDirName, lock.heldFilename()), held, lock.fileMode)
+	if err != nil {
+		return false, err
+	}
+	if info.Shared {
+		data, err := json.Marshal(readers{lock.newReaderInfo()})
+		if err != nil {
+			return false, err
+		}
+		err = lock.fs.WriteFile(path.Join(tempDirName, readersFilename), data, lock.fileMode)
 		if err != nil {
 			return false, err
 		}
 	}
 	// Now move the temp directory to the lock directory.
-	err = utils.ReplaceFile(tempDirName, lock.lockDir())
+	err = lock.fs.Rename(tempDirName, lock.lockDir())
 	if err != nil {
-		// Any error on rename means we failed.
-		// Beaten to it, clean up temporary directory.
-		os.RemoveAll(tempDirName)
+		// The temporary directory only disappears if the rename
+		// happened; another goroutine using the receiver may hold
+		// the lock, so its nonce alone doesn't show that.
+		if _, statErr := lock.fs.Stat(tempDirName); os.IsNotExist(statErr) && lock.IsLockHeld() {
+			// The rename happened, but reported an error; for
+			// instance it couldn't be flushed to disk. Don't leave
+			// the lock in a state nobody knows about.
+			acquired = true
+			lock.fs.RemoveAll(lock.lockDir())
+			return false, err
+		}
+		// Any other error on rename means we failed.
+		// Beaten to it, the temporary directory is cleaned up above.
 		return false, nil
 	}
 	// We now have the lock.
+	acquired = true
+	if err := lock.recordGeneration(info); err != nil {
+		// The lock is still usable, so don't give it up.
+		logger.Warningf("cannot record generation of lock %q: %v", lock.name, err)
+	}
 	return true, nil
 }
 
-// lockLoop tries to acquire the lock. If the acquisition fails, the
-// continueFunc is run to see if the function should continue waiting.
-func (lock *Lock) lockLoop(message string, continueFunc func() error) error {
-	var heldMessage = ""
+// tempDir creates a new temporary directory in the lock's parent
+// directory, or the one set by WithTempDir, and returns its name. The
+// name starts with "." as that isn't valid in a lock name. The directory
+// becomes the lock directory once it is complete, so it is created with
+// the lock's directory mode.
+func (lock *Lock) tempDir() (string, error) {
 	for {
-		acquired, err := lock.acquire(message)
+		parent := lock.parent
+		if lock.tempParent != "" {
+			parent = lock.tempParent
+		}
+		name := path.Join(parent, fmt.Sprintf(".%x%d", lock.nonce, rand.Uint32()))
+		err := lock.fs.Mkdir(name, lock.dirMode)
+		if err == nil {
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// lockLoop tries to acquire the lock by calling try. If the acquisition
+// fails, the continueFunc is run to see if the function should continue
+// waiting. If abort is closed while waiting between attempts, the
+// continueFunc is run again immediately rather than after the full wait
+// delay. If the lock has a watcher, the next attempt is also made as
+// soon as it reports a change. A lock held under an expired lease, or
+// left incomplete for longer than incompleteLockAge, is broken and the
+// acquisition retried; so it is when dead readers are removed from a
+// shared lock. Closing abort also gives up waiting for a shared lock's
+// guard, returning the continueFunc's error.
+func (lock *Lock) lockLoop(message string, try func() (bool, error), continueFunc func() error, abort <-chan struct{}) error {
+	var heldMessage = ""
+	changes, stop := lock.watch()
+	defer stop()
+	lock.observer.OnAcquireStart()
+	start := lock.clock.Now()
+	for attempt := 0; ; attempt++ {
+		acquired, err := try()
+		if err == errGuardAborted {
+			return lock.aborted(continueFunc)
+		}
 		if err != nil {
+			lock.debugf("lock %q: attempt %d failed: %v", lock.name, attempt, err)
 			return err
 		}
 		if acquired {
+			lock.acquired()
+			waited := lock.clock.Now().Sub(start)
+			lock.observer.OnAcquireSuccess(waited)
+			lock.debugf("lock %q: acquired after %s with message %q", lock.name, waited, message)
 			return nil
 		}
+		if attempt == 0 {
+			lock.observer.OnContended()
+		}
+		lock.debugHolder(attempt)
+		broken, err := lock.breakIfExpired()
+		if err != nil {
+			return err
+		}
+		if broken {
+			continue
+		}
+		broken, err = lock.breakIfIncomplete()
+		if err != nil {
+			return err
+		}
+		if broken {
+			continue
+		}
+		pruned, err := lock.pruneDeadReaders(abort)
+		if err == errGuardAborted {
+			return lock.aborted(continueFunc)
+		}
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			continue
+		}
 		if err = continueFunc(); err != nil {
+			lock.debugf("lock %q: gave up: %v", lock.name, err)
 			return err
 		}
 		currMessage := lock.Message()
@@ -146,8 +842,80 @@ func (lock *Lock) lockLoop(message string, continueFunc func() error) error {
 			logger.Infof("attempted lock failed %q, %s, currently held: %s", lock.name, message, currMessage)
 			heldMessage = currMessage
 		}
-		time.Sleep(LockWaitDelay)
+		delay, retry := lock.waitDelay(attempt)
+		if !retry {
+			lock.debugf("lock %q: gave up after %d attempts", lock.name, attempt+1)
+			return ErrTimeout
+		}
+		select {
+		case <-abort:
+			if err = continueFunc(); err != nil {
+				lock.debugf("lock %q: gave up: %v", lock.name, err)
+				return err
+			}
+		case <-changes:
+		case <-lock.clock.After(delay):
+		}
+	}
+}
+
+// aborted returns the error with which lockLoop gives up when its abort
+// channel is closed while waiting for a guard: that returned by the
+// continueFunc, which knows why the channel was closed.
+func (lock *Lock) aborted(continueFunc func() error) error {
+	err := continueFunc()
+	if err == nil {
+		err = errGuardAborted
+	}
+	lock.debugf("lock %q: gave up: %v", lock.name, err)
+	return err
+}
+
+// tryExclusive returns a function that makes a single attempt to
+// acquire the lock exclusively, for use with lockLoop.
+func (lock *Lock) tryExclusive(message string, ttl time.Duration) func() (bool, error) {
+	return func() (bool, error) {
+		if !lock.reentrant {
+			return lock.acquire(message, ttl)
+		}
+		lock.mu.Lock()
+		defer lock.mu.Unlock()
+		if lock.depth > 0 && lock.IsLockHeld() {
+			lock.depth++
+			return true, nil
+		}
+		acquired, err := lock.acquire(message, ttl)
+		if acquired {
+			lock.depth = 1
+		}
+		return acquired, err
+	}
+}
+
+// minRetryDelay is the shortest time a lock waits between attempts to
+// acquire it, so that a retry strategy asking for no delay at all, such
+// as a zero Backoff, doesn't make it spin.
+const minRetryDelay = time.Millisecond
+
+// waitDelay returns how long to wait after the given number of failed
+// attempts to acquire the lock, and whether to try again at all.
+func (lock *Lock) waitDelay(attempt int) (time.Duration, bool) {
+	delay := LockWaitDelay
+	if lock.retry != nil {
+		var retry bool
+		if delay, retry = lock.retry.NextDelay(attempt); !retry {
+			return 0, false
+		}
+		if delay < minRetryDelay {
+			delay = minRetryDelay
+		}
+	} else if lock.retryDelay > 0 {
+		delay = lock.retryDelay
+	}
+	if attempt == 0 && lock.jitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(lock.jitter)))
 	}
+	return delay, true
 }
 
 // Lock blocks until it is able to acquire the lock.  Since we are dealing
@@ -159,71 +927,813 @@ func (lock *Lock) Lock(message string) error {
 	// The continueFunc is effectively a no-op, causing continual looping
 	// until the lock is acquired.
 	continueFunc := func() error { return nil }
-	return lock.lockLoop(message, continueFunc)
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, nil)
+}
+
+// LockContext blocks until it is able to acquire the lock, or until the
+// given context is done, in which case the context's error is returned.
+// See `Lock` for information about the message.
+func (lock *Lock) LockContext(ctx context.Context, message string) error {
+	continueFunc := func() error { return ctx.Err() }
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, ctx.Done())
+}
+
+// Acquire is like LockContext, but also returns a function that
+// unlocks the lock, so that the release can be deferred straight away.
+// Only the first call of the function unlocks the lock; later calls
+// do nothing and return nil.
+func (lock *Lock) Acquire(ctx context.Context, message string) (release func() error, err error) {
+	if err := lock.LockContext(ctx, message); err != nil {
+		return nil, err
+	}
+	return lock.unlockOnce(), nil
+}
+
+// unlockOnce returns a function that unlocks the lock the first time it
+// is called, and does nothing after that.
+func (lock *Lock) unlockOnce() func() error {
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			err = lock.Unlock()
+		})
+		return err
+	}
+}
+
+// WithLock acquires the lock as LockContext does, runs fn while holding
+// it, and then unlocks it. The lock is released even if fn panics, in
+// which case the panic carries on once it has been. The error returned
+// by fn is returned; if there isn't one, any error from unlocking is
+// returned instead.
+func (lock *Lock) WithLock(ctx context.Context, message string, fn func() error) error {
+	if err := lock.LockContext(ctx, message); err != nil {
+		return err
+	}
+	return lock.runHolding(fn)
+}
+
+// DoWithTimeout is like WithLock, but gives up if it can't acquire the
+// lock within the given duration, as LockWithTimeout does. It reports
+// whether fn was run, along with the error that WithLock would return;
+// giving up isn't an error.
+func (lock *Lock) DoWithTimeout(timeout time.Duration, message string, fn func() error) (bool, error) {
+	acquired, err := lock.TryLockWithCancel(timeout, nil, message)
+	if !acquired {
+		return false, err
+	}
+	return true, lock.runHolding(fn)
+}
+
+// runHolding runs fn while the receiver holds the lock, and unlocks it
+// afterwards, for WithLock.
+func (lock *Lock) runHolding(fn func() error) error {
+	release := lock.unlockOnce()
+	// If fn panics, this is all that releases the lock.
+	defer release()
+	err := fn()
+	if releaseErr := release(); releaseErr != nil {
+		if err != nil {
+			logger.Warningf("cannot release lock %q: %v", lock.name, releaseErr)
+		} else {
+			err = releaseErr
+		}
+	}
+	return err
 }
 
 // LockWithTimeout tries to acquire the lock. If it cannot acquire the lock
 // within the given duration, it returns ErrTimeout.  See `Lock` for
 // information about the message.
 func (lock *Lock) LockWithTimeout(duration time.Duration, message string) error {
-	deadline := time.Now().Add(duration)
+	deadline := lock.clock.Now().Add(duration)
 	continueFunc := func() error {
-		if time.Now().After(deadline) {
+		if lock.clock.Now().After(deadline) {
 			return ErrTimeout
 		}
 		return nil
 	}
-	return lock.lockLoop(message, continueFunc)
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, nil)
+}
+
+// TryAcquire makes a single attempt to acquire the lock exclusively,
+// without waiting, and reports whether it succeeded. It is the building
+// block of the methods that wait for the lock, which add retrying, and
+// the breaking of expired leases and incomplete locks; it can be used to
+// build other ways of waiting. See `Lock` for information about the
+// message.
+func (lock *Lock) TryAcquire(message string) (bool, error) {
+	acquired, err := lock.tryExclusive(message, 0)()
+	if acquired {
+		lock.acquired()
+	}
+	return acquired, err
+}
+
+// TryLockOnce is the same as TryAcquire.
+func (lock *Lock) TryLockOnce(message string) (bool, error) {
+	return lock.TryAcquire(message)
+}
+
+// errCancelled is used internally by TryLockWithCancel to stop waiting.
+var errCancelled = errors.New("lock attempt cancelled")
+
+// TryLockWithCancel tries to acquire the lock exclusively for up to the
+// given duration, and reports whether it succeeded. If the cancel
+// channel is closed first, it gives up straight away. See `Lock` for
+// information about the message.
+func (lock *Lock) TryLockWithCancel(timeout time.Duration, cancel <-chan struct{}, message string) (bool, error) {
+	return lock.tryLockUntil(lock.clock.Now().Add(timeout), cancel, message)
+}
+
+// TryLockDeadline tries to acquire the lock exclusively until the given
+// time, as told by the lock's clock, and reports whether it succeeded.
+// At least one attempt is made, even if the deadline has passed. See
+// `Lock` for information about the message.
+func (lock *Lock) TryLockDeadline(deadline time.Time, message string) (bool, error) {
+	return lock.tryLockUntil(deadline, nil, message)
+}
+
+// TryLockInfo is like TryLockWithCancel without the cancel channel,
+// but if the lock isn't acquired it also returns information about
+// whoever is holding it, if that can be read, for use in reporting
+// the failure.
+func (lock *Lock) TryLockInfo(timeout time.Duration, message string) (bool, *LockInfo, error) {
+	acquired, err := lock.TryLockWithCancel(timeout, nil, message)
+	if acquired || err != nil {
+		return acquired, nil, err
+	}
+	holder, err := lock.Holder()
+	if err != nil {
+		// The lock may have been released in the meantime.
+		return false, nil, nil
+	}
+	return false, holder, nil
+}
+
+// tryLockUntil tries to acquire the lock exclusively until the given
+// deadline, or until cancel is closed, and reports whether it
+// succeeded.
+func (lock *Lock) tryLockUntil(deadline time.Time, cancel <-chan struct{}, message string) (bool, error) {
+	continueFunc := func() error {
+		select {
+		case <-cancel:
+			return errCancelled
+		default:
+		}
+		if lock.clock.Now().After(deadline) {
+			return ErrTimeout
+		}
+		return nil
+	}
+	err := lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, cancel)
+	switch err {
+	case nil:
+		return true, nil
+	case ErrTimeout, errCancelled:
+		return false, nil
+	}
+	return false, err
+}
+
+// LockWithTTL blocks until it is able to acquire the lock, which it
+// takes as a lease that expires after the given duration. Once the lease
+// has expired, anyone else trying to acquire the lock may break it and
+// take it for themselves. The expiry time is saved with the lock, so it
+// is honoured even if the holder is restarted, but as it is compared
+// with the clocks of other processes, possibly on other machines, it is
+// only reliable when those clocks are roughly synchronised. See `Lock`
+// for information about the message.
+func (lock *Lock) LockWithTTL(ttl time.Duration, message string) error {
+	continueFunc := func() error { return nil }
+	return lock.lockLoop(message, lock.tryExclusive(message, ttl), continueFunc, nil)
+}
+
+// RenewLease extends the lease on the lock so that it expires the given
+// duration from now. If the lock was not taken as a lease, it becomes
+// one. If the lock is not held by the receiver, ErrLockNotHeld is
+// returned.
+func (lock *Lock) RenewLease(ttl time.Duration) error {
+	info, err := lock.readHeld()
+	if err != nil || info.Shared || !bytes.Equal(info.Nonce, lock.nonce) {
+		return ErrLockNotHeld
+	}
+	expires := lock.clock.Now().UTC().Add(ttl)
+	info.Expires = &expires
+	return lock.writeHeld(info)
+}
+
+// Touch records that the receiver, holding the lock exclusively, is
+// still alive, so that others can tell it from a holder that has gone
+// away without releasing the lock. The time is saved with the held
+// information, and reported as the Heartbeat of the LockInfo returned
+// by Holder. If the lock is not held exclusively by the receiver,
+// ErrLockNotHeld is returned.
+func (lock *Lock) Touch() error {
+	info, err := lock.readHeld()
+	if err != nil || info.Shared || !bytes.Equal(info.Nonce, lock.nonce) {
+		return ErrLockNotHeld
+	}
+	now := lock.clock.Now().UTC()
+	info.Heartbeat = &now
+	return lock.writeHeld(info)
+}
+
+// Recover takes over the lock if it was left held exclusively by a
+// process on this machine that no longer exists, using a Lock with the
+// same identity as the receiver, set with WithIdentity. It reports
+// whether the receiver now holds the lock. The time the lock was
+// acquired, its message and any lease are kept. A lock held by a
+// process that is still running is never taken over, so of several
+// Locks with the same identity recovering the lock at once, only one
+// succeeds.
+func (lock *Lock) Recover() (bool, error) {
+	if lock.identity == "" {
+		return false, nil
+	}
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld || err == ErrIncompleteLock {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Shared || info.Identity != lock.identity {
+		return false, nil
+	}
+	if bytes.Equal(info.Nonce, lock.nonce) {
+		return true, nil
+	}
+	hostname, _ := os.Hostname()
+	if info.Hostname != hostname || info.PID <= 0 || processExists(info.PID) {
+		return false, nil
+	}
+	claimed, err := lock.claimHeld("recovered", heldBy(info.Nonce))
+	if claimed == "" {
+		return false, err
+	}
+	info.Nonce = lock.nonce
+	info.PID = os.Getpid()
+	info.Hostname = hostname
+	if err := lock.writeHeld(info); err != nil {
+		return false, lock.unclaim(claimed, err)
+	}
+	lock.fs.RemoveAll(claimed)
+	if lock.reentrant {
+		lock.mu.Lock()
+		lock.depth = 1
+		lock.mu.Unlock()
+	}
+	lock.acquired()
+	logger.Infof("recovered lock %q as %q", lock.name, lock.identity)
+	return true, nil
+}
+
+// claimHeld moves the held file aside, if the held information still
+// satisfies the given condition once it has been moved, and returns the
+// name it was moved to. Only one of several Locks claiming the lock at
+// once can move the file; the others find it gone, and get the empty
+// string. The suffix says what the claim is for.
+func (lock *Lock) claimHeld(suffix string, still func(*heldInfo) bool) (string, error) {
+	claimed := path.Join(lock.lockDir(), fmt.Sprintf(".%s.%x%d.%s", lock.heldFilename(), lock.nonce, rand.Uint32(), suffix))
+	if err := lock.fs.Rename(lock.heldFile(), claimed); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	data, err := lock.fs.ReadFile(claimed)
+	if err != nil {
+		return "", lock.unclaim(claimed, err)
+	}
+	if !still(parseHeld(data)) {
+		// The held file changed after the caller read it; the lock
+		// was claimed by someone else, released and taken again, or
+		// touched or renewed by its holder.
+		return "", lock.unclaim(claimed, nil)
+	}
+	return claimed, nil
+}
+
+// heldBy returns a condition for claimHeld satisfied while the lock is
+// held by the holder with the given nonce.
+func heldBy(nonce []byte) func(*heldInfo) bool {
+	return func(info *heldInfo) bool {
+		return bytes.Equal(info.Nonce, nonce)
+	}
+}
+
+// unclaim puts back a held file moved aside by claimHeld, returning err
+// unless that fails. If the lock has gone in the meantime, there is
+// nothing to put back.
+func (lock *Lock) unclaim(claimed string, err error) error {
+	if renameErr := lock.fs.Rename(claimed, lock.heldFile()); renameErr != nil && !os.IsNotExist(renameErr) {
+		return renameErr
+	}
+	return err
+}
+
+// writeHeld replaces the held file of the lock with the given
+// information.
+func (lock *Lock) writeHeld(info *heldInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return lock.replaceFile(lock.heldFilename(), data)
+}
+
+// replaceFile replaces the named file in the lock directory with one
+// containing the given data. The new file is written alongside the old
+// one and moved into place, so readers always see one or the other in
+// full.
+func (lock *Lock) replaceFile(filename string, data []byte) error {
+	// The random number keeps concurrent calls from using the same
+	// temporary file.
+	tempFile := path.Join(lock.lockDir(), fmt.Sprintf(".%s.%x%d", filename, lock.nonce, rand.Uint32()))
+	if err := lock.fs.WriteFile(tempFile, data, lock.fileMode); err != nil {
+		lock.fs.RemoveAll(tempFile)
+		return err
+	}
+	if err := lock.fs.Rename(tempFile, path.Join(lock.lockDir(), filename)); err != nil {
+		lock.fs.RemoveAll(tempFile)
+		return err
+	}
+	return nil
+}
+
+// breakIfExpired breaks the lock if it is held under a lease that has
+// expired, and reports whether it did so.
+func (lock *Lock) breakIfExpired() (bool, error) {
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld || err == ErrIncompleteLock {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	expired := func(info *heldInfo) bool {
+		return info.Expires != nil && !lock.clock.Now().Before(*info.Expires)
+	}
+	if !expired(info) {
+		return false, nil
+	}
+	logger.Infof("lease on lock %q held by pid %d on %q expired at %s", lock.name, info.PID, info.Hostname, *info.Expires)
+	return lock.breakHeld(info, "expired", expired)
+}
+
+// breakIfIncomplete breaks the lock if its directory has been without
+// valid held information for longer than incompleteLockAge, and reports
+// whether it did so. Locks are only moved into place once they are
+// complete, so this only happens when a process has crashed part way
+// through creating one by hand, or with a version of this package that
+// wrote the held file afterwards.
+func (lock *Lock) breakIfIncomplete() (bool, error) {
+	if _, err := lock.readHeld(); err != ErrIncompleteLock {
+		return false, nil
+	}
+	info, err := lock.fs.Stat(lock.lockDir())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !olderThan(info, incompleteLockAge) {
+		return false, nil
+	}
+	// Someone else may have reclaimed the lock and acquired it since,
+	// so it is moved aside first, and put back unless it is the same
+	// directory, still incomplete and unchanged. Only one of several
+	// Locks reclaiming it at once can move it.
+	tempDirName := lock.hiddenName(fmt.Sprintf("%x%d.broken", lock.nonce, rand.Uint32()))
+	if err := lock.fs.Rename(lock.lockDir(), tempDirName); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	asideInfo, err := lock.fs.Stat(tempDirName)
+	if err != nil {
+		return false, err
+	}
+	data, err := lock.fs.ReadFile(path.Join(tempDirName, lock.heldFilename()))
+	complete := err == nil && len(data) > 0
+	if complete || !os.SameFile(asideInfo, info) || !asideInfo.ModTime().Equal(info.ModTime()) {
+		if err := lock.fs.Rename(tempDirName, lock.lockDir()); err != nil {
+			// A new lock was taken in its place, so this one is
+			// lost anyway.
+			logger.Warningf("cannot restore lock %q: %v", lock.name, err)
+			return false, lock.fs.RemoveAll(tempDirName)
+		}
+		return false, nil
+	}
+	logger.Infof("lock %q has been incomplete since %s", lock.name, info.ModTime())
+	if err := lock.fs.RemoveAll(tempDirName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockWithStaleBreak tries to acquire the lock, breaking the lock if it
+// is held by a process on this machine that no longer exists. Locks held
+// by processes on other machines are never broken. If the lock cannot be
+// acquired within maxWait, ErrTimeout is returned; if the context is
+// done first, the context's error is returned. See `Lock` for
+// information about the message.
+func (lock *Lock) LockWithStaleBreak(ctx context.Context, maxWait time.Duration, message string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	deadline := lock.clock.Now().Add(maxWait)
+	continueFunc := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if lock.clock.Now().After(deadline) {
+			return ErrTimeout
+		}
+		return lock.breakIfDead(hostname)
+	}
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, ctx.Done())
+}
+
+// breakIfDead breaks the lock if it is held by a process on the given
+// host that no longer exists.
+func (lock *Lock) breakIfDead(hostname string) error {
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld || err == ErrIncompleteLock {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The process that took a shared lock need not be the one holding it.
+	dead := func(info *heldInfo) bool {
+		return !info.Shared && info.Hostname == hostname && info.PID > 0 && !processExists(info.PID)
+	}
+	if !dead(info) {
+		return nil
+	}
+	logger.Infof("lock %q is held by pid %d, which no longer exists", lock.name, info.PID)
+	_, err = lock.breakHeld(info, "dead", dead)
+	return err
+}
+
+// LockBreakingStale tries to acquire the lock, breaking the lock if its
+// holder hasn't shown it is alive, by acquiring the lock or with Touch,
+// for longer than staleAfter. Unlike LockWithStaleBreak, this works
+// whichever machine the holder is on, as long as the holder touches the
+// lock more often than that and the clocks of the machines agree to
+// well within it. Locks held for shared use are never broken. If the
+// context is done first, the context's error is returned. See `Lock`
+// for information about the message.
+func (lock *Lock) LockBreakingStale(ctx context.Context, staleAfter time.Duration, message string) error {
+	continueFunc := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return lock.breakIfStale(staleAfter)
+	}
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, ctx.Done())
+}
+
+// breakIfStale breaks the lock if it is held exclusively by a holder
+// that last touched it more than staleAfter ago.
+func (lock *Lock) breakIfStale(staleAfter time.Duration) error {
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld || err == ErrIncompleteLock {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stale := func(info *heldInfo) bool {
+		return !info.Shared && lock.clock.Now().Sub(info.heartbeat()) > staleAfter
+	}
+	if !stale(info) {
+		return nil
+	}
+	logger.Infof("lock %q held by pid %d on %q was last touched at %s", lock.name, info.PID, info.Hostname, info.heartbeat())
+	_, err = lock.breakHeld(info, "stale", stale)
+	return err
+}
+
+// breakHeld breaks the lock if it is still held by the holder described
+// by info, and the held information still satisfies the condition for
+// breaking it, and reports whether it did so. Breaking the directory
+// alone could break a lock taken just after someone else broke the one
+// the caller read, or one its holder has touched or renewed since, so
+// the holder's held file is claimed first; only one of several Locks
+// breaking the same holder at once succeeds. The suffix says why the
+// lock is being broken.
+func (lock *Lock) breakHeld(info *heldInfo, suffix string, breakable func(*heldInfo) bool) (bool, error) {
+	claimed, err := lock.claimHeld(suffix, func(claimed *heldInfo) bool {
+		return bytes.Equal(claimed.Nonce, info.Nonce) && breakable(claimed)
+	})
+	if claimed == "" {
+		return false, err
+	}
+	if err := lock.breakLock(info.lockInfo(lock.name), nil); err != nil && err != ErrLockNotHeld {
+		return false, err
+	}
+	return true, nil
 }
 
 // LockWithFunc blocks until it is able to acquire the lock.  If the lock is failed to
 // be acquired, the continueFunc is called prior to the sleeping.  If the
 // continueFunc returns an error, that error is returned from LockWithFunc.
 func (lock *Lock) LockWithFunc(message string, continueFunc func() error) error {
-	return lock.lockLoop(message, continueFunc)
+	return lock.lockLoop(message, lock.tryExclusive(message, 0), continueFunc, nil)
+}
+
+// readHeld reads the held file of the lock, whoever holds it. If the
+// lock is not held ErrLockNotHeld is returned; if the lock directory
+// exists without a usable held file ErrIncompleteLock is returned.
+func (lock *Lock) readHeld() (*heldInfo, error) {
+	data, err := lock.fs.ReadFile(lock.heldFile())
+	if os.IsNotExist(err) {
+		if _, err := lock.fs.Stat(lock.lockDir()); err == nil {
+			return nil, ErrIncompleteLock
+		}
+		return nil, ErrLockNotHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrIncompleteLock
+	}
+	return parseHeld(data), nil
+}
+
+// parseHeld parses the contents of a held file. Held files written by
+// older versions of this package contain only the nonce.
+func parseHeld(data []byte) *heldInfo {
+	var info heldInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return &heldInfo{Nonce: data}
+	}
+	return &info
 }
 
-// IsLockHeld returns whether the lock is currently held by the receiver.
+// IsLockHeld returns whether the lock is currently held by the receiver,
+// either exclusively or for shared use.
 func (lock *Lock) IsLockHeld() bool {
-	heldNonce, err := ioutil.ReadFile(lock.heldFile())
+	info, err := lock.readHeld()
 	if err != nil {
 		return false
 	}
-	return bytes.Equal(heldNonce, lock.nonce)
+	if info.Shared {
+		readers, err := lock.readReaders()
+		return err == nil && readers.index(lock.nonce) >= 0
+	}
+	return bytes.Equal(info.Nonce, lock.nonce)
 }
 
-// Unlock releases a held lock.  If the lock is not held ErrLockNotHeld is
-// returned.
+// String returns a description of the lock for debugging, saying
+// whether it is held by the receiver. It only reads the lock from disk.
+func (lock *Lock) String() string {
+	return fmt.Sprintf("fslock{name=%s dir=%s held=%t}", lock.name, lock.parent, lock.IsLockHeld())
+}
+
+// Holder returns information about whoever currently holds the lock,
+// which need not be the receiver. If the lock is not held,
+// ErrLockNotHeld is returned. If the lock directory exists but the held
+// information can't be read yet, ErrIncompleteLock is returned. If the
+// lock was taken by a version of this package that didn't record
+// information about the holder, the fields of the returned LockInfo are
+// all zero.
+func (lock *Lock) Holder() (*LockInfo, error) {
+	info, err := lock.readHeld()
+	if err != nil {
+		return nil, err
+	}
+	return info.lockInfo(lock.name), nil
+}
+
+// lockInfo returns the exported form of the held information for the
+// named lock.
+func (info *heldInfo) lockInfo(name string) *LockInfo {
+	return &LockInfo{
+		Name:       name,
+		Shared:     info.Shared,
+		PID:        info.PID,
+		Hostname:   info.Hostname,
+		AcquiredAt: info.AcquiredAt,
+		Message:    info.Message,
+		Heartbeat:  info.heartbeat(),
+		CallSite:   info.CallSite,
+	}
+}
+
+// heartbeat returns the time the holder last touched the lock, or when
+// it acquired it if it never has.
+func (info *heldInfo) heartbeat() time.Time {
+	if info.Heartbeat != nil {
+		return *info.Heartbeat
+	}
+	return info.AcquiredAt
+}
+
+// HolderPID returns the process id of whoever currently holds the lock,
+// which need not be the receiver. The errors returned are the same as
+// for Holder. Zero is returned if the lock was taken by a version of
+// this package that didn't record the process id.
+func (lock *Lock) HolderPID() (int, error) {
+	info, err := lock.Holder()
+	if err != nil {
+		return 0, err
+	}
+	return info.PID, nil
+}
+
+// AcquiredAt returns the time, to the second, at which whoever
+// currently holds the lock acquired it; for a shared lock, this is when
+// it was first taken. The errors returned are the same as for Holder.
+// The zero time is returned if the lock was taken by a version of this
+// package that didn't record the time.
+func (lock *Lock) AcquiredAt() (time.Time, error) {
+	info, err := lock.Holder()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.AcquiredAt, nil
+}
+
+// Unlock releases a held lock, whether it is held exclusively or for
+// shared use.  If the lock is not held ErrLockNotHeld is returned.
 func (lock *Lock) Unlock() error {
+	if err := lock.unlock(); err != nil {
+		if err == ErrLockNotHeld && lock.lost() {
+			return ErrLockGone
+		}
+		return err
+	}
 	if !lock.IsLockHeld() {
+		lock.released()
+	}
+	lock.debugf("lock %q: unlocked", lock.name)
+	return nil
+}
+
+// acquired records that the receiver has acquired the lock.
+func (lock *Lock) acquired() {
+	lock.mu.Lock()
+	lock.holding = true
+	lock.mu.Unlock()
+	lock.startWatchdog()
+}
+
+// released records that the receiver no longer holds the lock.
+func (lock *Lock) released() {
+	lock.mu.Lock()
+	lock.holding = false
+	lock.mu.Unlock()
+	lock.stopWatchdog()
+}
+
+// lost is called when the receiver finds it doesn't hold the lock, and
+// reports whether that is because the lock was removed along with its
+// parent directory while the receiver held it.
+func (lock *Lock) lost() bool {
+	lock.mu.Lock()
+	holding := lock.holding
+	lock.depth = 0
+	lock.mu.Unlock()
+	if !holding {
+		return false
+	}
+	lock.released()
+	_, err := lock.fs.Stat(lock.parent)
+	return os.IsNotExist(err)
+}
+
+// unlock implements Unlock.
+func (lock *Lock) unlock() error {
+	info, err := lock.readHeld()
+	if err != nil {
+		return ErrLockNotHeld
+	}
+	if info.Shared {
+		return lock.unlockShared()
+	}
+	if !bytes.Equal(info.Nonce, lock.nonce) {
+		return ErrLockNotHeld
+	}
+	if lock.reentrant {
+		lock.mu.Lock()
+		defer lock.mu.Unlock()
+		if lock.depth > 1 {
+			lock.depth--
+			return nil
+		}
+		lock.depth = 0
+	}
+	return lock.release(lock.nonce)
+}
+
+// UnlockIfHeld releases the lock if it is held by the receiver, and does
+// nothing otherwise. Unlike Unlock, it is always safe to defer a call to
+// UnlockIfHeld, even if the lock may already have been released,
+// broken, or removed along with its directory.
+func (lock *Lock) UnlockIfHeld() error {
+	if err := lock.Unlock(); err != ErrLockNotHeld && err != ErrLockGone {
+		return err
+	}
+	return nil
+}
+
+// release removes the lock directory, releasing the lock, if it is
+// still held by the holder with the given nonce; otherwise
+// ErrLockNotHeld is returned. The held file is claimed first, so that
+// of several goroutines releasing the lock at once, only one does so,
+// and none releases a lock taken by someone else in the meantime.
+func (lock *Lock) release(nonce []byte) error {
+	claimed, err := lock.claimHeld("released", heldBy(nonce))
+	if err != nil {
+		return err
+	}
+	if claimed == "" {
 		return ErrLockNotHeld
 	}
 	// To ensure reasonable unlocking, we should rename to a temp name, and delete that.
-	tempLockName := fmt.Sprintf(".%s.%x", lock.name, lock.nonce)
+	// The random number keeps goroutines sharing the receiver from
+	// using the same name.
+	tempLockName := fmt.Sprintf(".%s.%x%d", lock.name, lock.nonce, rand.Uint32())
 	tempDirName := path.Join(lock.parent, tempLockName)
 	// Now move the lock directory to the temp directory to release the lock.
-	if err := utils.ReplaceFile(lock.lockDir(), tempDirName); err != nil {
-		return err
+	if err := lock.fs.Rename(lock.lockDir(), tempDirName); err != nil {
+		if os.IsNotExist(err) {
+			// The lock was broken after it was claimed.
+			return ErrLockNotHeld
+		}
+		return lock.unclaim(claimed, err)
 	}
 	// And now cleanup.
-	return os.RemoveAll(tempDirName)
+	return lock.fs.RemoveAll(tempDirName)
+}
+
+// Stat returns information about the lock directory, which exists only
+// while the lock is held. If the lock isn't held, the error satisfies
+// os.IsNotExist. The modification time of the directory is roughly when
+// the lock was acquired, which is useful for locks taken by versions of
+// this package that didn't record the time.
+func (lock *Lock) Stat() (os.FileInfo, error) {
+	return lock.fs.Stat(lock.lockDir())
 }
 
 // IsLocked returns true if the lock is currently held by anyone.
 func (lock *Lock) IsLocked() bool {
-	_, err := os.Stat(lock.heldFile())
+	_, err := lock.fs.Stat(lock.heldFile())
 	return err == nil
 }
 
-// BreakLock forcably breaks the lock that is currently being held.
+// BreakLock forcibly breaks the lock that is currently being held,
+// whoever holds it. If the lock is not held ErrLockNotHeld is returned.
+// Information about the holder of the broken lock is logged.
 func (lock *Lock) BreakLock() error {
-	return os.RemoveAll(lock.lockDir())
+	holder, holderErr := lock.Holder()
+	return lock.breakLock(holder, holderErr)
 }
 
-// Message returns the saved message, or the empty string if there is no
-// saved message.
+// breakLock implements BreakLock, logging the given information about
+// the holder of the lock, or the error reading it.
+func (lock *Lock) breakLock(holder *LockInfo, holderErr error) error {
+	// As with unlocking, rename the lock directory out of the way before
+	// deleting it so that it disappears all at once.
+	tempLockName := fmt.Sprintf(".%s.%x%d.broken", lock.name, lock.nonce, rand.Uint32())
+	tempDirName := path.Join(lock.parent, tempLockName)
+	if err := lock.fs.Rename(lock.lockDir(), tempDirName); err != nil {
+		if os.IsNotExist(err) {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+	if holderErr == nil {
+		logger.Infof("broke lock %q held by pid %d on %q since %s", lock.name, holder.PID, holder.Hostname, holder.AcquiredAt)
+		lock.debugf("lock %q: broken, was held by pid %d on %q since %s", lock.name, holder.PID, holder.Hostname, holder.AcquiredAt)
+	} else {
+		logger.Infof("broke lock %q, held by unknown holder: %v", lock.name, holderErr)
+		lock.debugf("lock %q: broken, was held by unknown holder: %v", lock.name, holderErr)
+	}
+	return lock.fs.RemoveAll(tempDirName)
+}
+
+// Message returns the message saved by whoever currently holds the
+// lock, or the empty string if there is no saved message.
 func (lock *Lock) Message() string {
-	message, err := ioutil.ReadFile(lock.messageFile())
+	info, err := lock.readHeld()
+	if err != nil {
+		return ""
+	}
+	if info.Message != "" {
+		return info.Message
+	}
+	// The lock may have been taken by an older version of this package.
+	message, err := lock.fs.ReadFile(lock.messageFile())
 	if err != nil {
 		return ""
 	}
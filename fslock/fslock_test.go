@@ -4,11 +4,17 @@
 package fslock_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,6 +30,47 @@ const (
 	longWait  = 10 * time.Second
 )
 
+// fakeClock is a fslock.Clock whose time only passes when it is waited
+// on, so that waiting takes no real time at all.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.Advance(d)
+	ch := make(chan time.Time, 1)
+	ch <- clock.Now()
+	return ch
+}
+
+// Advance moves the clock on by d.
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+}
+
+// delayClock is a fakeClock that records the delays it is asked to
+// wait for.
+type delayClock struct {
+	fakeClock
+	delays []time.Duration
+}
+
+func (clock *delayClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	clock.delays = append(clock.delays, d)
+	clock.mu.Unlock()
+	return clock.fakeClock.After(d)
+}
+
 type fslockSuite struct {
 	testing.IsolationSuite
 	lockDelay time.Duration
@@ -66,6 +113,63 @@ func (s *fslockSuite) TestInvalidNames(c *gc.C) {
 		dir := c.MkDir()
 		_, err := fslock.NewLock(dir, name)
 		c.Assert(err, gc.ErrorMatches, "Invalid lock name .*")
+		c.Assert(errors.Is(err, fslock.ErrInvalidName), gc.Equals, true)
+		var nameErr *fslock.InvalidNameError
+		c.Assert(errors.As(err, &nameErr), gc.Equals, true)
+		c.Assert(nameErr.Name, gc.Equals, name)
+	}
+}
+
+func (s *fslockSuite) TestRelaxedNames(c *gc.C) {
+	for _, name := range []string{
+		"MyLock",
+		"lock_1",
+		"1lock",
+		"_private",
+		"with.dot-dash",
+	} {
+		_, err := fslock.NewLock(c.MkDir(), name, fslock.WithNameValidator(fslock.RelaxedNames))
+		c.Assert(err, gc.IsNil)
+	}
+	for _, name := range []string{
+		".start",
+		"-start",
+		"no+plus",
+		"no/slash",
+		"no$dollar",
+	} {
+		_, err := fslock.NewLock(c.MkDir(), name, fslock.WithNameValidator(fslock.RelaxedNames))
+		c.Assert(err, gc.ErrorMatches, `Invalid lock name .*`)
+	}
+}
+
+func (s *fslockSuite) TestNameValidator(c *gc.C) {
+	validate := func(name string) error {
+		if name == "forbidden" {
+			return fmt.Errorf("%s is not allowed", name)
+		}
+		return nil
+	}
+	lock, err := fslock.NewLock(c.MkDir(), "Any Name at all", fslock.WithNameValidator(validate))
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	_, err = fslock.NewLock(c.MkDir(), "forbidden", fslock.WithNameValidator(validate))
+	c.Assert(err, gc.ErrorMatches, `Invalid lock name "forbidden": forbidden is not allowed`)
+	c.Assert(errors.Is(err, fslock.ErrInvalidName), gc.Equals, true)
+	c.Assert(errors.Unwrap(err), gc.ErrorMatches, "forbidden is not allowed")
+
+	// Some names are never allowed.
+	for _, name := range []string{
+		"",
+		".start",
+		"no/slash",
+		"no\\backslash",
+		"..",
+	} {
+		_, err := fslock.NewLock(c.MkDir(), name, fslock.WithNameValidator(validate))
+		c.Assert(err, gc.ErrorMatches, `Invalid lock name .*`)
 	}
 }
 
@@ -155,53 +259,304 @@ func (s *fslockSuite) TestLockBlocks(c *gc.C) {
 	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
 }
 
-func (s *fslockSuite) TestLockWithTimeoutUnlocked(c *gc.C) {
+func (s *fslockSuite) TestWithRetryDelay(c *gc.C) {
+	s.PatchValue(&fslock.LockWaitDelay, longWait)
 	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	time.Sleep(shortWait)
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	// Had the package delay been used, this would take longWait.
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait / 2):
+		c.Fatalf("lock not acquired using the retry delay")
+	}
+}
+
+// assertMode checks that the named file has no more permissions than
+// mode, allowing for the umask, and all of the owner's.
+func assertMode(c *gc.C, name string, mode os.FileMode) {
+	info, err := os.Stat(name)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Mode().Perm()&^mode, gc.Equals, os.FileMode(0))
+	c.Assert(info.Mode().Perm()&0700, gc.Equals, mode&0700)
+}
+
+func (s *fslockSuite) TestDefaultModes(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("permissions are not supported on windows")
+	}
+	dir := path.Join(c.MkDir(), "locks")
 	lock, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
 
-	err = lock.LockWithTimeout(shortWait, "")
+	assertMode(c, dir, 0755)
+	assertMode(c, path.Join(dir, "testing"), 0755)
+	assertMode(c, path.Join(dir, "testing", "held"), 0644)
+}
+
+func (s *fslockSuite) TestWithModes(c *gc.C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("permissions are not supported on windows")
+	}
+	dir := path.Join(c.MkDir(), "locks")
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithDirMode(0750), fslock.WithFileMode(0640))
+	c.Assert(err, gc.IsNil)
+	err = lock.LockShared("")
 	c.Assert(err, gc.IsNil)
+
+	assertMode(c, dir, 0750)
+	assertMode(c, path.Join(dir, "testing"), 0750)
+	assertMode(c, path.Join(dir, "testing", "held"), 0640)
+	assertMode(c, path.Join(dir, "testing", "readers"), 0640)
 }
 
-func (s *fslockSuite) TestLockWithTimeoutLocked(c *gc.C) {
+func (s *fslockSuite) TestBackoffDelay(c *gc.C) {
+	backoff := fslock.Backoff{
+		Initial: time.Millisecond,
+		Max:     10 * time.Millisecond,
+		Factor:  2,
+	}
+	for i, expect := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		c.Check(backoff.Delay(i), gc.Equals, expect*time.Millisecond)
+	}
+}
+
+func (s *fslockSuite) TestBackoffDelayJitter(c *gc.C) {
+	backoff := fslock.Backoff{
+		Initial: 100 * time.Millisecond,
+		Factor:  1,
+		Jitter:  0.5,
+	}
+	for i := 0; i < 100; i++ {
+		delay := backoff.Delay(i)
+		c.Assert(delay <= 100*time.Millisecond, gc.Equals, true)
+		c.Assert(delay >= 50*time.Millisecond, gc.Equals, true)
+	}
+}
+
+func (s *fslockSuite) TestWithBackoff(c *gc.C) {
+	s.PatchValue(&fslock.LockWaitDelay, longWait)
 	dir := c.MkDir()
 	lock1, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
-	lock2, err := fslock.NewLock(dir, "testing")
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithBackoff(fslock.Backoff{
+		Initial: time.Millisecond,
+		Max:     5 * time.Millisecond,
+		Factor:  2,
+		Jitter:  0.1,
+	}))
 	c.Assert(err, gc.IsNil)
 
 	err = lock1.Lock("")
 	c.Assert(err, gc.IsNil)
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
 
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
 	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.IsNil)
+}
+
+// limitedRetries is a RetryStrategy that waits for longer after each
+// attempt, and stops after a few.
+type limitedRetries int
+
+func (n limitedRetries) NextDelay(attempt int) (time.Duration, bool) {
+	return time.Duration(attempt+1) * time.Millisecond, attempt < int(n)
+}
+
+func (s *fslockSuite) TestWithRetryStrategy(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	clock := &delayClock{}
+	lock2, err := fslock.NewLock(dir, "testing",
+		fslock.WithClock(clock),
+		fslock.WithRetryDelay(time.Hour),
+		fslock.WithRetryStrategy(limitedRetries(3)),
+	)
+	c.Assert(err, gc.IsNil)
+	err = lock2.Lock("")
 	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(clock.delays, gc.DeepEquals, []time.Duration{
+		time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond,
+	})
+
+	acquired, err := lock2.TryLockWithCancel(longWait, nil, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	err = lock2.Lock("")
+	c.Assert(err, gc.IsNil)
 }
 
-func (s *fslockSuite) TestUnlock(c *gc.C) {
+func (s *fslockSuite) TestZeroRetryDelay(c *gc.C) {
 	dir := c.MkDir()
-	lock, err := fslock.NewLock(dir, "testing")
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	// Strategies asking for no delay still wait between attempts,
+	// rather than spinning.
+	for _, option := range []fslock.Option{
+		fslock.WithBackoff(fslock.Backoff{}),
+		fslock.WithRetryStrategy(fslock.ConstantDelay(0)),
+	} {
+		clock := &delayClock{}
+		lock2, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock), option)
+		c.Assert(err, gc.IsNil)
+		err = lock2.LockWithTimeout(10*time.Millisecond, "")
+		c.Assert(err, gc.Equals, fslock.ErrTimeout)
+		c.Assert(clock.delays, gc.Not(gc.HasLen), 0)
+		for _, delay := range clock.delays {
+			c.Assert(delay, gc.Equals, time.Millisecond)
+		}
+	}
+}
+
+func (s *fslockSuite) TestConstantDelay(c *gc.C) {
+	var strategy fslock.RetryStrategy = fslock.ConstantDelay(time.Second)
+	for i := 0; i < 3; i++ {
+		delay, retry := strategy.NextDelay(i)
+		c.Check(delay, gc.Equals, time.Second)
+		c.Check(retry, gc.Equals, true)
+	}
+}
+
+func (s *fslockSuite) TestWithInitialJitter(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	for i := 0; i < 10; i++ {
+		clock := &delayClock{}
+		lock2, err := fslock.NewLock(dir, "testing",
+			fslock.WithClock(clock),
+			fslock.WithRetryDelay(time.Second),
+			fslock.WithInitialJitter(100*time.Millisecond),
+		)
+		c.Assert(err, gc.IsNil)
+		err = lock2.LockWithTimeout(3*time.Second, "")
+		c.Assert(err, gc.Equals, fslock.ErrTimeout)
+
+		c.Assert(len(clock.delays) > 1, gc.Equals, true)
+		c.Assert(clock.delays[0] < 100*time.Millisecond, gc.Equals, true)
+		for _, delay := range clock.delays[1:] {
+			c.Assert(delay, gc.Equals, time.Second)
+		}
+	}
+}
+
+func (s *fslockSuite) TestWithReentrancy(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithReentrancy())
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing", fslock.WithReentrancy())
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.LockWithTimeout(shortWait, "")
 	c.Assert(err, gc.IsNil)
 
+	// Other locks still block.
+	err = other.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
 	err = lock.Unlock()
 	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
 }
 
-func (s *fslockSuite) TestIsLocked(c *gc.C) {
+func (s *fslockSuite) TestWithoutReentrancy(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+}
+
+func (s *fslockSuite) TestReentrancyAfterBreak(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithReentrancy())
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.BreakLock()
+	c.Assert(err, gc.IsNil)
+
+	// Acquiring the lock afresh starts counting again.
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestLockWithTimeoutUnlocked(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestLockWithTimeoutWithClock(c *gc.C) {
+	clock := &fakeClock{now: time.Now()}
 	dir := c.MkDir()
 	lock1, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
-	lock2, err := fslock.NewLock(dir, "testing")
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock), fslock.WithRetryDelay(time.Minute))
 	c.Assert(err, gc.IsNil)
 
 	err = lock1.Lock("")
 	c.Assert(err, gc.IsNil)
 
-	c.Assert(lock1.IsLocked(), gc.Equals, true)
-	c.Assert(lock2.IsLocked(), gc.Equals, true)
+	start := time.Now()
+	err = lock2.LockWithTimeout(time.Hour, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(time.Since(start) < longWait, gc.Equals, true)
+	c.Assert(clock.Now().Sub(start) > time.Hour, gc.Equals, true)
 }
 
-func (s *fslockSuite) TestBreakLock(c *gc.C) {
+func (s *fslockSuite) TestLockWithTimeoutLocked(c *gc.C) {
 	dir := c.MkDir()
 	lock1, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
@@ -211,66 +566,1425 @@ func (s *fslockSuite) TestBreakLock(c *gc.C) {
 	err = lock1.Lock("")
 	c.Assert(err, gc.IsNil)
 
-	err = lock2.BreakLock()
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+}
+
+func (s *fslockSuite) TestFailedAcquireRemovesTempDirs(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock2.IsLocked(), gc.Equals, false)
 
-	// Normally locks are broken due to client crashes, not duration.
-	err = lock1.Unlock()
-	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
 
-	// Breaking a non-existant isn't an error
-	err = lock2.BreakLock()
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+
+	// Only the lock and its generation are left.
+	infos, err := ioutil.ReadDir(dir)
 	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 2)
+	c.Assert(infos[0].Name(), gc.Equals, ".testing.generation")
+	c.Assert(infos[1].Name(), gc.Equals, "testing")
 }
 
-func (s *fslockSuite) TestMessage(c *gc.C) {
+func (s *fslockSuite) TestLockContextUnlocked(c *gc.C) {
 	dir := c.MkDir()
 	lock, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock.Message(), gc.Equals, "")
 
-	err = lock.Lock("my message")
+	err = lock.LockContext(context.Background(), "")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock.Message(), gc.Equals, "my message")
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
 
-	// Unlocking removes the message.
-	err = lock.Unlock()
+func (s *fslockSuite) TestLockContextCancelled(c *gc.C) {
+	s.PatchValue(&fslock.LockWaitDelay, longWait)
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock.Message(), gc.Equals, "")
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- lock2.LockContext(ctx, "")
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		c.Assert(err, gc.Equals, context.Canceled)
+	case <-time.After(longWait / 2):
+		c.Fatalf("LockContext did not return after cancellation")
+	}
+	c.Assert(lock2.IsLockHeld(), gc.Equals, false)
 }
 
-func (s *fslockSuite) TestMessageAcrossLocks(c *gc.C) {
+func (s *fslockSuite) TestLockContextDeadline(c *gc.C) {
 	dir := c.MkDir()
 	lock1, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
 	lock2, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
 
-	err = lock1.Lock("very busy")
+	err = lock1.Lock("")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock2.Message(), gc.Equals, "very busy")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = lock2.LockContext(ctx, "")
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
 }
 
-func (s *fslockSuite) TestInitialMessageWhenLocking(c *gc.C) {
+func (s *fslockSuite) TestHolderPID(c *gc.C) {
 	dir := c.MkDir()
-	lock, err := fslock.NewLock(dir, "testing")
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
 	c.Assert(err, gc.IsNil)
 
-	err = lock.Lock("initial message")
+	_, err = lock2.HolderPID()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.Lock("")
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock.Message(), gc.Equals, "initial message")
+	pid, err := lock2.HolderPID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(pid, gc.Equals, os.Getpid())
+}
 
-	err = lock.Unlock()
+func (s *fslockSuite) TestHolderPIDOldFormat(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(path.Join(dir, "testing", "held"), []byte("0123456789abcdef"), 0644)
 	c.Assert(err, gc.IsNil)
 
-	err = lock.LockWithTimeout(shortWait, "initial timeout message")
+	pid, err := lock.HolderPID()
 	c.Assert(err, gc.IsNil)
-	c.Assert(lock.Message(), gc.Equals, "initial timeout message")
+	c.Assert(pid, gc.Equals, 0)
+	c.Assert(lock.IsLocked(), gc.Equals, true)
+	c.Assert(lock.IsLockHeld(), gc.Equals, false)
 }
 
-func (s *fslockSuite) TestStress(c *gc.C) {
-	const lockAttempts = 200
+func (s *fslockSuite) TestHolder(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	_, err = lock2.Holder()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	before := time.Now().Truncate(time.Second)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	after := time.Now()
+
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+	info, err := lock2.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.PID, gc.Equals, os.Getpid())
+	c.Assert(info.Hostname, gc.Equals, hostname)
+	c.Assert(info.AcquiredAt.Before(before), gc.Equals, false)
+	c.Assert(info.AcquiredAt.After(after), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestHolderOldFormat(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(path.Join(dir, "testing", "held"), []byte("0123456789abcdef"), 0644)
+	c.Assert(err, gc.IsNil)
+
+	info, err := lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(*info, gc.DeepEquals, fslock.LockInfo{Name: "testing"})
+}
+
+func (s *fslockSuite) TestAcquiredAt(c *gc.C) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	lock1, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	_, err = lock1.AcquiredAt()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	for _, lock := range []*fslock.Lock{lock1, lock2} {
+		acquiredAt, err := lock.AcquiredAt()
+		c.Assert(err, gc.IsNil)
+		c.Assert(acquiredAt.Equal(clock.now), gc.Equals, true)
+	}
+}
+
+func (s *fslockSuite) TestHolderIncomplete(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+
+	_, err = lock.Holder()
+	c.Assert(err, gc.Equals, fslock.ErrIncompleteLock)
+	_, err = lock.HolderPID()
+	c.Assert(err, gc.Equals, fslock.ErrIncompleteLock)
+
+	err = ioutil.WriteFile(path.Join(dir, "testing", "held"), nil, 0644)
+	c.Assert(err, gc.IsNil)
+	_, err = lock.Holder()
+	c.Assert(err, gc.Equals, fslock.ErrIncompleteLock)
+}
+
+func (s *fslockSuite) TestLockBreaksAbandonedIncompleteLock(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(path.Join(dir, "testing"), old, old)
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockWithTimeout(longWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestAbandonedIncompleteLockBrokenOnce(c *gc.C) {
+	dir := c.MkDir()
+	err := os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(path.Join(dir, "testing"), old, old)
+	c.Assert(err, gc.IsNil)
+
+	checkBreakRace(c, dir, func(lock *fslock.Lock) error {
+		return lock.LockWithTimeout(10*shortWait, "")
+	})
+}
+
+func (s *fslockSuite) TestLockWaitsOnRecentIncompleteLock(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+
+	// Whoever is creating the lock may still be writing it.
+	err = lock.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	_, err = lock.Holder()
+	c.Assert(err, gc.Equals, fslock.ErrIncompleteLock)
+}
+
+// writeHeld makes the named lock look as if it is held by the process
+// described by info.
+func writeHeld(c *gc.C, dir, name string, info map[string]interface{}) {
+	data, err := json.Marshal(info)
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, name), 0755)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(path.Join(dir, name, "held"), data, 0644)
+	c.Assert(err, gc.IsNil)
+}
+
+// deadPID returns the process id of a process that has exited.
+func deadPID(c *gc.C) int {
+	cmd := exec.Command(os.Args[0], "-test.run", "^$")
+	err := cmd.Run()
+	c.Assert(err, gc.IsNil)
+	return cmd.Process.Pid
+}
+
+func (s *fslockSuite) TestLockWithStaleBreakDeadHolder(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":    []byte("dead"),
+		"pid":      deadPID(c),
+		"hostname": hostname,
+	})
+
+	err = lock.LockWithStaleBreak(context.Background(), longWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestRenewedLeaseNotBroken(c *gc.C) {
+	checkRefreshRace(c, ".expired", func(lock *fslock.Lock) error {
+		return lock.LockWithTTL(time.Minute, "")
+	}, func(lock *fslock.Lock) error {
+		return lock.RenewLease(time.Minute)
+	}, func(lock *fslock.Lock) error {
+		return lock.LockWithTimeout(10*shortWait, "")
+	}, fslock.ErrTimeout)
+}
+
+func (s *fslockSuite) TestTouchedLockNotBrokenAsStale(c *gc.C) {
+	checkRefreshRace(c, ".stale", func(lock *fslock.Lock) error {
+		return lock.Lock("")
+	}, func(lock *fslock.Lock) error {
+		return lock.Touch()
+	}, func(lock *fslock.Lock) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*shortWait)
+		defer cancel()
+		return lock.LockBreakingStale(ctx, time.Minute, "")
+	}, context.DeadlineExceeded)
+}
+
+func (s *fslockSuite) TestLockWithStaleBreakDeadHolderBrokenOnce(c *gc.C) {
+	dir := c.MkDir()
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":    []byte("dead"),
+		"pid":      deadPID(c),
+		"hostname": hostname,
+	})
+	checkBreakRace(c, dir, func(lock *fslock.Lock) error {
+		return lock.LockWithStaleBreak(context.Background(), 10*shortWait, "")
+	})
+}
+
+func (s *fslockSuite) TestLockWithStaleBreakLiveHolder(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock2.LockWithStaleBreak(context.Background(), shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockWithStaleBreakOtherHost(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":    []byte("dead"),
+		"pid":      deadPID(c),
+		"hostname": "some.other.host",
+	})
+
+	err = lock.LockWithStaleBreak(context.Background(), shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(lock.IsLocked(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockBreakingStale(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":       []byte("stale"),
+		"pid":         1,
+		"hostname":    "some.other.host",
+		"acquired-at": time.Now().Add(-2 * time.Hour),
+		"heartbeat":   time.Now().Add(-time.Hour),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), longWait)
+	defer cancel()
+	err = lock.LockBreakingStale(ctx, time.Minute, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockBreakingStaleTouched(c *gc.C) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Now()}
+	holder, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	clock.Advance(time.Hour)
+	err = holder.Touch()
+	c.Assert(err, gc.IsNil)
+
+	// The holder last touched the lock just now, although it took the
+	// lock long ago.
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = lock.LockBreakingStale(ctx, 2*time.Hour, "")
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(holder.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockBreakingStaleConcurrently(c *gc.C) {
+	const concurrentLocks = 10
+	dir := c.MkDir()
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":     []byte("stale"),
+		"heartbeat": time.Now().Add(-time.Hour),
+	})
+
+	// Only the stale lock may be broken, so there must never be more
+	// than one holder at once.
+	var holders int32
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentLocks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+			c.Check(err, gc.IsNil)
+			err = lock.LockBreakingStale(context.Background(), time.Minute, "")
+			c.Check(err, gc.IsNil)
+			c.Check(atomic.AddInt32(&holders, 1), gc.Equals, int32(1))
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			c.Check(lock.Unlock(), gc.IsNil)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *fslockSuite) TestAcquire(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	release, err := lock1.Acquire(context.Background(), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+	err = release()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock1.IsLocked(), gc.Equals, false)
+
+	// Releasing again doesn't touch the lock, even if it is held by
+	// someone else.
+	err = lock2.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = release()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestAcquireCancelled(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	release, err := lock2.Acquire(ctx, "")
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(release, gc.IsNil)
+}
+
+func (s *fslockSuite) TestWithLock(c *gc.C) {
+	lock, err := fslock.NewLock(c.MkDir(), "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.WithLock(context.Background(), "working", func() error {
+		c.Assert(lock.IsLockHeld(), gc.Equals, true)
+		c.Assert(lock.Message(), gc.Equals, "working")
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+
+	err = lock.WithLock(context.Background(), "", func() error {
+		return fmt.Errorf("failed")
+	})
+	c.Assert(err, gc.ErrorMatches, "failed")
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestWithLockPanic(c *gc.C) {
+	lock, err := fslock.NewLock(c.MkDir(), "testing")
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(func() {
+		lock.WithLock(context.Background(), "", func() error {
+			panic("oops")
+		})
+	}, gc.PanicMatches, "oops")
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestHelpersReleaseOnPanic(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	for i, run := range []func(fn func() error){
+		func(fn func() error) { lock.WithLock(context.Background(), "", fn) },
+		func(fn func() error) { lock.DoWithTimeout(shortWait, "", fn) },
+	} {
+		c.Logf("helper %d", i)
+		func() {
+			defer func() {
+				// The panic carries on with its own value.
+				c.Assert(recover(), gc.Equals, "oops")
+			}()
+			run(func() error {
+				c.Assert(lock.IsLockHeld(), gc.Equals, true)
+				panic("oops")
+			})
+		}()
+		// The lock is free for anyone to take straight away.
+		acquired, err := other.TryAcquire("")
+		c.Assert(err, gc.IsNil)
+		c.Assert(acquired, gc.Equals, true)
+		err = other.Unlock()
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *fslockSuite) TestWithLockCancelled(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = lock2.WithLock(ctx, "", func() error {
+		c.Fatalf("function run without the lock")
+		return nil
+	})
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+}
+
+func (s *fslockSuite) TestDoWithTimeout(c *gc.C) {
+	lock, err := fslock.NewLock(c.MkDir(), "testing")
+	c.Assert(err, gc.IsNil)
+
+	ran, err := lock.DoWithTimeout(shortWait, "working", func() error {
+		c.Assert(lock.IsLockHeld(), gc.Equals, true)
+		c.Assert(lock.Message(), gc.Equals, "working")
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(ran, gc.Equals, true)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+
+	ran, err = lock.DoWithTimeout(shortWait, "", func() error {
+		return fmt.Errorf("failed")
+	})
+	c.Assert(err, gc.ErrorMatches, "failed")
+	c.Assert(ran, gc.Equals, true)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+
+	c.Assert(func() {
+		lock.DoWithTimeout(shortWait, "", func() error {
+			panic("oops")
+		})
+	}, gc.PanicMatches, "oops")
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestDoWithTimeoutHeld(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	ran, err := lock2.DoWithTimeout(shortWait, "", func() error {
+		c.Fatalf("function run without the lock")
+		return nil
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(ran, gc.Equals, false)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockWithTimeoutErrors(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.IsNil)
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(errors.Is(err, fslock.ErrTimeout), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestTryAcquire(c *gc.C) {
+	dir := path.Join(c.MkDir(), "locks")
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	go func() {
+		time.Sleep(shortWait)
+		c.Check(holder.Unlock(), gc.IsNil)
+	}()
+	// A loop of its own, waiting for the lock.
+	attempts := 0
+	for timeout := time.After(longWait); ; attempts++ {
+		acquired, err := lock.TryAcquire("custom")
+		c.Assert(err, gc.IsNil)
+		if acquired {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("Expected lock acquisition")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	c.Assert(attempts > 0, gc.Equals, true)
+	c.Assert(lock.Message(), gc.Equals, "custom")
+
+	// The receiver knows it acquired the lock.
+	err = os.RemoveAll(dir)
+	c.Assert(err, gc.IsNil)
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockGone)
+}
+
+func (s *fslockSuite) TestTryLockOnce(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	acquired, err := lock1.TryLockOnce("first")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+	c.Assert(lock1.Message(), gc.Equals, "first")
+
+	acquired, err = lock2.TryLockOnce("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, false)
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	acquired, err = lock2.TryLockOnce("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+}
+
+func (s *fslockSuite) TestTryLockWithCancel(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	acquired, err := lock1.TryLockWithCancel(longWait, nil, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+
+	acquired, err = lock2.TryLockWithCancel(shortWait, nil, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestTryLockDeadline(c *gc.C) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Now()}
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+
+	acquired, err := lock1.TryLockDeadline(time.Now().Add(longWait), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+
+	deadline := clock.Now().Add(time.Minute)
+	acquired, err = lock2.TryLockDeadline(deadline, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+	c.Assert(clock.Now().After(deadline), gc.Equals, true)
+
+	// A deadline that has passed still gets one attempt.
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	acquired, err = lock2.TryLockDeadline(deadline, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+}
+
+func (s *fslockSuite) TestTryLockInfo(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	acquired, holder, err := lock1.TryLockInfo(shortWait, "busy")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, true)
+	c.Assert(holder, gc.IsNil)
+
+	acquired, holder, err = lock2.TryLockInfo(shortWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(acquired, gc.Equals, false)
+	c.Assert(holder, gc.NotNil)
+	c.Assert(holder.PID, gc.Equals, os.Getpid())
+	c.Assert(holder.Message, gc.Equals, "busy")
+}
+
+func (s *fslockSuite) TestTryLockWithCancelCancelled(c *gc.C) {
+	s.PatchValue(&fslock.LockWaitDelay, longWait)
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	cancel := make(chan struct{})
+	type result struct {
+		acquired bool
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		acquired, err := lock2.TryLockWithCancel(longWait, cancel, "")
+		done <- result{acquired, err}
+	}()
+	time.Sleep(shortWait)
+	close(cancel)
+
+	// Cancelling doesn't wait for the next attempt.
+	select {
+	case r := <-done:
+		c.Assert(r.err, gc.IsNil)
+		c.Assert(r.acquired, gc.Equals, false)
+	case <-time.After(longWait / 2):
+		c.Fatalf("Lock attempt not cancelled")
+	}
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockWithTTL(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.LockWithTTL(shortWait, "")
+	c.Assert(err, gc.IsNil)
+
+	// The second lock breaks the expired lease.
+	err = lock2.LockWithTimeout(longWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestLockWithTTLNotExpired(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.LockWithTTL(longWait, "")
+	c.Assert(err, gc.IsNil)
+
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockWithTTLWithClock(c *gc.C) {
+	clock := &fakeClock{now: time.Now()}
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock), fslock.WithRetryDelay(time.Minute))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.LockWithTTL(time.Hour, "")
+	c.Assert(err, gc.IsNil)
+
+	// Waiting for the lease to expire takes no time at all.
+	err = lock2.LockWithTimeout(2*time.Hour, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestRenewLease(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.LockWithTTL(shortWait, "")
+	c.Assert(err, gc.IsNil)
+	err = lock1.RenewLease(longWait)
+	c.Assert(err, gc.IsNil)
+
+	err = lock2.LockWithTimeout(2*shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+
+	// Renewing leaves nothing else behind in the lock directory.
+	infos, err := ioutil.ReadDir(path.Join(dir, "testing"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 1)
+	c.Assert(infos[0].Name(), gc.Equals, "held")
+}
+
+func (s *fslockSuite) TestRenewLeaseNotHeld(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.RenewLease(longWait)
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.LockWithTTL(longWait, "")
+	c.Assert(err, gc.IsNil)
+	err = lock2.RenewLease(longWait)
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestTouch(c *gc.C) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Now()}
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithClock(clock))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	info, err := lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Heartbeat.Equal(info.AcquiredAt), gc.Equals, true)
+
+	clock.Advance(time.Hour)
+	err = lock.Touch()
+	c.Assert(err, gc.IsNil)
+	info, err = lock.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Heartbeat.Equal(clock.Now()), gc.Equals, true)
+	c.Assert(info.AcquiredAt.Before(info.Heartbeat), gc.Equals, true)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestTouchNotHeld(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Touch()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock2.Touch()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestHeldRewriteConcurrentReads(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("rewritten")
+	c.Assert(err, gc.IsNil)
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			var err error
+			if i%2 == 0 {
+				err = lock.Touch()
+			} else {
+				err = lock.RenewLease(time.Hour)
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	// Readers never see the held file missing or half written while it
+	// is being replaced.
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			c.Assert(err, gc.IsNil)
+			finished = true
+		default:
+		}
+		info, err := reader.Holder()
+		c.Assert(err, gc.IsNil)
+		c.Assert(info.Message, gc.Equals, "rewritten")
+		c.Assert(lock.IsLockHeld(), gc.Equals, true)
+		c.Assert(reader.IsLocked(), gc.Equals, true)
+	}
+}
+
+// writeLeftHeldBy writes a lock held by a process that has exited, which
+// used the given identity.
+func writeLeftHeldBy(c *gc.C, dir, name, identity string) {
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, name, map[string]interface{}{
+		"nonce":    []byte("restarted"),
+		"pid":      deadPID(c),
+		"hostname": hostname,
+		"message":  "running",
+		"identity": identity,
+	})
+}
+
+func (s *fslockSuite) TestRecover(c *gc.C) {
+	dir := c.MkDir()
+	writeLeftHeldBy(c, dir, "testing", "service")
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithIdentity("service"))
+	c.Assert(err, gc.IsNil)
+
+	recovered, err := lock.Recover()
+	c.Assert(err, gc.IsNil)
+	c.Assert(recovered, gc.Equals, true)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	c.Assert(lock.Message(), gc.Equals, "running")
+	pid, err := lock.HolderPID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(pid, gc.Equals, os.Getpid())
+
+	// Recovering a lock already held does nothing.
+	recovered, err = lock.Recover()
+	c.Assert(err, gc.IsNil)
+	c.Assert(recovered, gc.Equals, true)
+
+	// Nothing is left behind in the lock directory.
+	infos, err := ioutil.ReadDir(path.Join(dir, "testing"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 1)
+
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestRecoverNotOwnLock(c *gc.C) {
+	dir := c.MkDir()
+	writeLeftHeldBy(c, dir, "testing", "service")
+	other, err := fslock.NewLock(dir, "testing", fslock.WithIdentity("other"))
+	c.Assert(err, gc.IsNil)
+	anonymous, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	for _, lock := range []*fslock.Lock{other, anonymous} {
+		recovered, err := lock.Recover()
+		c.Assert(err, gc.IsNil)
+		c.Assert(recovered, gc.Equals, false)
+		c.Assert(lock.IsLockHeld(), gc.Equals, false)
+	}
+}
+
+func (s *fslockSuite) TestRecoverLiveHolder(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing", fslock.WithIdentity("service"))
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithIdentity("service"))
+	c.Assert(err, gc.IsNil)
+
+	recovered, err := lock.Recover()
+	c.Assert(err, gc.IsNil)
+	c.Assert(recovered, gc.Equals, false)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	recovered, err = lock.Recover()
+	c.Assert(err, gc.IsNil)
+	c.Assert(recovered, gc.Equals, false)
+	c.Assert(holder.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestRecoverConcurrently(c *gc.C) {
+	const concurrentLocks = 10
+	dir := c.MkDir()
+	writeLeftHeldBy(c, dir, "testing", "service")
+
+	locks := make([]*fslock.Lock, concurrentLocks)
+	for i := range locks {
+		lock, err := fslock.NewLock(dir, "testing", fslock.WithIdentity("service"))
+		c.Assert(err, gc.IsNil)
+		locks[i] = lock
+	}
+	results := make(chan bool, concurrentLocks)
+	for _, lock := range locks {
+		go func(lock *fslock.Lock) {
+			recovered, err := lock.Recover()
+			c.Check(err, gc.IsNil)
+			results <- recovered
+		}(lock)
+	}
+	recovered := 0
+	for range locks {
+		if <-results {
+			recovered++
+		}
+	}
+	c.Assert(recovered, gc.Equals, 1)
+	held := 0
+	for _, lock := range locks {
+		if lock.IsLockHeld() {
+			held++
+		}
+	}
+	c.Assert(held, gc.Equals, 1)
+}
+
+func (s *fslockSuite) TestExpiredLeaseFromDisk(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":   []byte("expired"),
+		"expires": time.Now().Add(-time.Minute),
+	})
+
+	err = lock.LockWithTimeout(longWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestExpiredLeaseBrokenOnce(c *gc.C) {
+	dir := c.MkDir()
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce":   []byte("expired"),
+		"expires": time.Now().Add(-time.Minute),
+	})
+	checkBreakRace(c, dir, func(lock *fslock.Lock) error {
+		return lock.LockWithTimeout(10*shortWait, "")
+	})
+}
+
+func (s *fslockSuite) TestWithoutParentCreate(c *gc.C) {
+	dir := path.Join(c.MkDir(), "locks")
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithoutParentCreate())
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(dir)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	// Acquisition fails straight away, rather than waiting.
+	err = lock.Lock("")
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+	_, err = os.Stat(dir)
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	err = os.Mkdir(dir, 0700)
+	c.Assert(err, gc.IsNil)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestNonceSource(c *gc.C) {
+	dir := c.MkDir()
+	const nonce = "0123456789abcdef"
+	lock1, err := fslock.NewLock(dir, "testing", fslock.WithNonceSource(strings.NewReader(nonce)))
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithNonceSource(strings.NewReader(nonce)))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadFile(path.Join(dir, "testing", "held"))
+	c.Assert(err, gc.IsNil)
+	var held struct {
+		Nonce []byte `json:"nonce"`
+	}
+	err = json.Unmarshal(data, &held)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(held.Nonce), gc.Equals, nonce)
+
+	// Locks with the same nonce can't be told apart.
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestNonceSourceError(c *gc.C) {
+	dir := c.MkDir()
+	_, err := fslock.NewLock(dir, "testing", fslock.WithNonceSource(strings.NewReader("short")))
+	c.Assert(err, gc.ErrorMatches, "cannot read lock nonce: unexpected EOF")
+}
+
+func (s *fslockSuite) TestNameAndDir(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Name(), gc.Equals, "testing")
+	c.Assert(lock.Dir(), gc.Equals, dir)
+}
+
+func (s *fslockSuite) TestString(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.String(), gc.Equals, "fslock{name=testing dir="+dir+" held=false}")
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(fmt.Sprint(lock), gc.Equals, "fslock{name=testing dir="+dir+" held=true}")
+}
+
+func (s *fslockSuite) TestUnlock(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestUnlockAfterParentRemoved(c *gc.C) {
+	dir := path.Join(c.MkDir(), "locks")
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = os.RemoveAll(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, false)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+
+	// Only the holder is told the lock has gone.
+	err = other.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockGone)
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestUnlockSharedAfterParentRemoved(c *gc.C) {
+	dir := path.Join(c.MkDir(), "locks")
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = os.RemoveAll(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, false)
+	err = lock.UnlockIfHeld()
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestUnlockIfHeld(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.UnlockIfHeld()
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.UnlockIfHeld()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+
+	// Unlocking twice.
+	err = lock.UnlockIfHeld()
+	c.Assert(err, gc.IsNil)
+	err = lock.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestUnlockIfHeldAfterBreak(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock2.BreakLock()
+	c.Assert(err, gc.IsNil)
+	err = lock2.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	// The lock now belongs to someone else, and is left alone.
+	err = lock1.UnlockIfHeld()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestIsLocked(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(lock1.IsLocked(), gc.Equals, true)
+	c.Assert(lock2.IsLocked(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestWithHeldFilename(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithHeldFilename("owner"))
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing", fslock.WithHeldFilename("owner"))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("renamed")
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(path.Join(dir, "testing", "owner"))
+	c.Assert(err, gc.IsNil)
+	_, err = os.Stat(path.Join(dir, "testing", "held"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	c.Assert(other.IsLocked(), gc.Equals, true)
+	c.Assert(other.Message(), gc.Equals, "renamed")
+	held, err := other.TryAcquire("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(held, gc.Equals, false)
+
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(other.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestWithHeldFilenameInvalid(c *gc.C) {
+	for _, name := range []string{".held", "a/b", `a\b`, "message", "readers"} {
+		c.Logf("name %q", name)
+		_, err := fslock.NewLock(c.MkDir(), "testing", fslock.WithHeldFilename(name))
+		c.Assert(err, gc.ErrorMatches, "invalid held filename .*")
+	}
+}
+
+func (s *fslockSuite) TestEqual(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	same, err := fslock.NewLock(dir+"/", "testing")
+	c.Assert(err, gc.IsNil)
+	otherName, err := fslock.NewLock(dir, "other")
+	c.Assert(err, gc.IsNil)
+	otherDir, err := fslock.NewLock(c.MkDir(), "testing")
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(lock.Equal(lock), gc.Equals, true)
+	c.Assert(lock.Equal(same), gc.Equals, true)
+	c.Assert(same.Equal(lock), gc.Equals, true)
+	c.Assert(lock.Equal(otherName), gc.Equals, false)
+	c.Assert(lock.Equal(otherDir), gc.Equals, false)
+	c.Assert(lock.Equal(nil), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestStat(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	_, err = lock.Stat()
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	info, err := lock.Stat()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Name(), gc.Equals, "testing")
+	c.Assert(info.IsDir(), gc.Equals, true)
+	c.Assert(time.Since(info.ModTime()) < longWait, gc.Equals, true)
+}
+
+func (s *fslockSuite) TestBreakLock(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	err = lock2.BreakLock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.IsLocked(), gc.Equals, false)
+
+	// Normally locks are broken due to client crashes, not duration.
+	err = lock1.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	// Breaking a lock that isn't held is reported.
+	err = lock2.BreakLock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestBreakLockRemovesEverything(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(path.Join(dir, "testing", "extra"), []byte("foo"), 0644)
+	c.Assert(err, gc.IsNil)
+
+	err = lock.BreakLock()
+	c.Assert(err, gc.IsNil)
+	// Only the generation of the lock is kept.
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 1)
+	c.Assert(infos[0].Name(), gc.Equals, ".testing.generation")
+}
+
+func (s *fslockSuite) TestBreakIncompleteLock(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+
+	err = lock.BreakLock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestMessage(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Message(), gc.Equals, "")
+
+	err = lock.Lock("my message")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Message(), gc.Equals, "my message")
+
+	// Unlocking removes the message.
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Message(), gc.Equals, "")
+}
+
+func (s *fslockSuite) TestMessageAcrossLocks(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("very busy")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2.Message(), gc.Equals, "very busy")
+}
+
+func (s *fslockSuite) TestHolderMessage(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("very busy")
+	c.Assert(err, gc.IsNil)
+	info, err := lock2.Holder()
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Message, gc.Equals, "very busy")
+
+	// The message is kept in the held information.
+	_, err = os.Stat(path.Join(dir, "testing", "message"))
+	c.Assert(os.IsNotExist(err), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestMessageOldFormat(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writeHeld(c, dir, "testing", map[string]interface{}{
+		"nonce": []byte("old"),
+	})
+	err = ioutil.WriteFile(path.Join(dir, "testing", "message"), []byte("old message"), 0644)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(lock.Message(), gc.Equals, "old message")
+}
+
+func (s *fslockSuite) TestInitialMessageWhenLocking(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("initial message")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Message(), gc.Equals, "initial message")
+
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockWithTimeout(shortWait, "initial timeout message")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.Message(), gc.Equals, "initial timeout message")
+}
+
+func (s *fslockSuite) TestStress(c *gc.C) {
+	const lockAttempts = 200
 	const concurrentLocks = 10
 
 	var counter = new(int64)
@@ -316,6 +2030,132 @@ func (s *fslockSuite) TestStress(c *gc.C) {
 	c.Assert(*counter, gc.Equals, int64(lockAttempts*concurrentLocks))
 }
 
+func (s *fslockSuite) TestConcurrentUseOfOneLock(c *gc.C) {
+	const lockAttempts = 20
+	const concurrentLocks = 10
+
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+	reentrant, err := fslock.NewLock(dir, "reentrant", fslock.WithReentrancy())
+	c.Assert(err, gc.IsNil)
+
+	// Goroutines taking the lock in turn still exclude each other, as
+	// each only releases the lock it took.
+	var holders int32
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentLocks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < lockAttempts; j++ {
+				c.Check(lock.Lock(""), gc.IsNil)
+				c.Check(atomic.AddInt32(&holders, 1), gc.Equals, int32(1))
+				c.Check(lock.IsLockHeld(), gc.Equals, true)
+				atomic.AddInt32(&holders, -1)
+				c.Check(lock.Unlock(), gc.IsNil)
+
+				c.Check(reentrant.Lock(""), gc.IsNil)
+				c.Check(reentrant.IsLockHeld(), gc.Equals, true)
+				c.Check(reentrant.Unlock(), gc.IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+	c.Assert(reentrant.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestConcurrentUnlockOfOneLock(c *gc.C) {
+	const attempts = 50
+
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+
+	// Two goroutines unlock the lock at once, while another Lock waits
+	// to take it. Only one of them releases it, and the other must not
+	// take away the lock acquired in the meantime.
+	for i := 0; i < attempts; i++ {
+		err := lock.Lock("")
+		c.Assert(err, gc.IsNil)
+		start := make(chan struct{})
+		unlocked := make(chan error, 2)
+		for j := 0; j < 2; j++ {
+			go func() {
+				<-start
+				unlocked <- lock.Unlock()
+			}()
+		}
+		locked := make(chan error, 1)
+		go func() {
+			<-start
+			locked <- other.LockWithTimeout(longWait, "")
+		}()
+		close(start)
+
+		released := 0
+		for j := 0; j < 2; j++ {
+			if err := <-unlocked; err == nil {
+				released++
+			} else {
+				c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+			}
+		}
+		c.Assert(released, gc.Equals, 1)
+		c.Assert(<-locked, gc.IsNil)
+		c.Assert(other.IsLockHeld(), gc.Equals, true)
+		err = other.Unlock()
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *fslockSuite) TestTimeoutStressLeavesNoGoroutines(c *gc.C) {
+	const lockAttempts = 20
+	const concurrentLocks = 20
+
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	before := runtime.NumGoroutine()
+
+	// Attempts time out while the lock is held, and then contend for it
+	// once it has been released.
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentLocks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := fslock.NewLock(dir, "testing")
+			c.Check(err, gc.IsNil)
+			for j := 0; j < lockAttempts; j++ {
+				err := lock.LockWithTimeout(time.Millisecond, "")
+				if err == fslock.ErrTimeout {
+					continue
+				}
+				c.Check(err, gc.IsNil)
+				c.Check(lock.Unlock(), gc.IsNil)
+			}
+		}()
+	}
+	time.Sleep(shortWait)
+	err = holder.Unlock()
+	c.Assert(err, gc.IsNil)
+	wg.Wait()
+
+	for timeout := time.After(longWait); runtime.NumGoroutine() > before; {
+		select {
+		case <-timeout:
+			c.Fatalf("%d goroutines left behind", runtime.NumGoroutine()-before)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
 func (s *fslockSuite) TestTomb(c *gc.C) {
 	const timeToDie = 200 * time.Millisecond
 	die := tomb.Tomb{}
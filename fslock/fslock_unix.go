@@ -0,0 +1,42 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build !windows
+
+package fslock
+
+import (
+	"os"
+	"syscall"
+)
+
+// syncDir flushes the directory entries of dir to stable storage.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// processExists reports whether a process with the given id is running
+// on this machine.
+func processExists(pid int) bool {
+	// Signal 0 performs the permission and existence checks without
+	// actually sending a signal.
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// sameFilesystem reports whether the directories described by the
+// given information are on the same filesystem. If that can't be told,
+// it reports that they are.
+func sameFilesystem(dir1, dir2 string, info1, info2 os.FileInfo) bool {
+	stat1, ok1 := info1.Sys().(*syscall.Stat_t)
+	stat2, ok2 := info2.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return true
+	}
+	return stat1.Dev == stat2.Dev
+}
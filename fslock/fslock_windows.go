@@ -0,0 +1,49 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// +build windows
+
+package fslock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// errorInvalidParameter is returned by OpenProcess when there is no
+// process with the requested id.
+const errorInvalidParameter = syscall.Errno(87)
+
+// syncDir is a no-op on Windows, where directories cannot be opened for
+// flushing. Renames are already made with MOVEFILE_WRITE_THROUGH by
+// utils.ReplaceFile, which doesn't return until the move is on disk.
+func syncDir(dir string) error {
+	return nil
+}
+
+// processExists reports whether a process with the given id is running
+// on this machine.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// Any other error, such as access being denied, means there
+		// is a process with that id.
+		serr, ok := err.(*os.SyscallError)
+		return !ok || serr.Err != errorInvalidParameter
+	}
+	p.Release()
+	return true
+}
+
+// sameFilesystem reports whether the given directories are on the same
+// volume. If that can't be told, it reports that they are.
+func sameFilesystem(dir1, dir2 string, info1, info2 os.FileInfo) bool {
+	abs1, err1 := filepath.Abs(dir1)
+	abs2, err2 := filepath.Abs(dir2)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return strings.EqualFold(filepath.VolumeName(abs1), filepath.VolumeName(abs2))
+}
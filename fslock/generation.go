@@ -0,0 +1,115 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each time a lock is acquired it is given a generation, which is one
+// more than that of the last time it was acquired. The last generation
+// is kept in a file alongside the lock directory, as the lock directory
+// goes when the lock is released. Only holders of the lock write the
+// file, once they have acquired it.
+//
+// If the file can't be read, perhaps because it was truncated by a
+// crash, the last generation is unknown. Starting again from zero would
+// hand out generations already used, so the count carries on from the
+// current time in nanoseconds instead, which is far beyond any count
+// reached by acquiring the lock, or from the highest generation seen by
+// the receiver if that is greater.
+
+// Generation returns the generation of the lock: a number which
+// increases each time the lock is acquired, so that callers can tell
+// whether the lock has been released and acquired again, perhaps by the
+// same holder, between two calls. Zero is returned if the lock was
+// taken by a version of this package that didn't record the generation.
+// The errors returned are the same as for Holder.
+func (lock *Lock) Generation() (uint64, error) {
+	info, err := lock.readHeld()
+	if err != nil {
+		return 0, err
+	}
+	lock.sawGeneration(info.Generation)
+	return info.Generation, nil
+}
+
+func (lock *Lock) generationFile() string {
+	return lock.hiddenName("generation")
+}
+
+// nextGeneration returns the generation with which the lock should be
+// acquired next.
+func (lock *Lock) nextGeneration() uint64 {
+	last, known := lock.lastGeneration()
+	if !known {
+		last = uint64(time.Now().UnixNano())
+		lock.generationMu.Lock()
+		if lock.generation > last {
+			last = lock.generation
+		}
+		lock.generationMu.Unlock()
+	}
+	return last + 1
+}
+
+// sawGeneration records that the lock has been seen with the given
+// generation.
+func (lock *Lock) sawGeneration(generation uint64) {
+	lock.generationMu.Lock()
+	defer lock.generationMu.Unlock()
+	if generation > lock.generation {
+		lock.generation = generation
+	}
+}
+
+// lastGeneration returns the generation with which the lock was last
+// acquired, or zero if it never has been, and whether it is known. It
+// isn't if the generation file can't be read or parsed.
+func (lock *Lock) lastGeneration() (uint64, bool) {
+	data, err := lock.fs.ReadFile(lock.generationFile())
+	if os.IsNotExist(err) {
+		return 0, true
+	}
+	if err != nil {
+		logger.Warningf("cannot read generation of lock %q: %v", lock.name, err)
+		return 0, false
+	}
+	generation, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		logger.Warningf("cannot parse generation of lock %q: %v", lock.name, err)
+		return 0, false
+	}
+	return generation, true
+}
+
+// recordGeneration saves the generation of the lock, just acquired by
+// the receiver with the given held information. If the lock was acquired
+// and released by someone else between the receiver choosing the
+// generation and taking the lock, the generation is moved on past theirs.
+func (lock *Lock) recordGeneration(info *heldInfo) error {
+	if last, known := lock.lastGeneration(); known && last >= info.Generation {
+		info.Generation = last + 1
+		if err := lock.writeHeld(info); err != nil {
+			return err
+		}
+	}
+	lock.sawGeneration(info.Generation)
+	data := []byte(strconv.FormatUint(info.Generation, 10))
+	temp := fmt.Sprintf("%s.%x%d", lock.generationFile(), lock.nonce, rand.Uint32())
+	if err := lock.fs.WriteFile(temp, data, lock.fileMode); err != nil {
+		lock.fs.RemoveAll(temp)
+		return err
+	}
+	if err := lock.fs.Rename(temp, lock.generationFile()); err != nil {
+		lock.fs.RemoveAll(temp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func assertGeneration(c *gc.C, lock *fslock.Lock, expect uint64) {
+	generation, err := lock.Generation()
+	c.Assert(err, gc.IsNil)
+	c.Assert(generation, gc.Equals, expect)
+}
+
+func (s *fslockSuite) TestGeneration(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	_, err = lock1.Generation()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock2, 1)
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	// The same holder taking the lock again gets a new generation.
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock2, 2)
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	err = lock2.LockShared("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock1, 3)
+	err = lock1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock1, 3)
+}
+
+func (s *fslockSuite) TestGenerationKeptByUpgradeAndDowngrade(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 1)
+	err = lock.Upgrade(context.Background())
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 1)
+	err = lock.Downgrade()
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 1)
+}
+
+func (s *fslockSuite) TestGenerationContinuesFromDisk(c *gc.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(path.Join(dir, ".testing.generation"), []byte("41"), 0644)
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 42)
+	data, err := ioutil.ReadFile(path.Join(dir, ".testing.generation"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "42")
+}
+
+func (s *fslockSuite) TestGenerationCorruptFile(c *gc.C) {
+	dir := c.MkDir()
+	generationFile := path.Join(dir, ".testing.generation")
+	err := ioutil.WriteFile(generationFile, []byte("41"), 0644)
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	other, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 42)
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	// The last generation is unknown, but the generation still goes
+	// up, for the same Lock and for any other.
+	last := uint64(42)
+	for _, l := range []*fslock.Lock{lock, other, lock} {
+		err = ioutil.WriteFile(generationFile, []byte("junk"), 0644)
+		c.Assert(err, gc.IsNil)
+		err = l.Lock("")
+		c.Assert(err, gc.IsNil)
+		generation, err := l.Generation()
+		c.Assert(err, gc.IsNil)
+		c.Assert(generation > last, gc.Equals, true, gc.Commentf("generation %d after %d", generation, last))
+		last = generation
+		err = l.Unlock()
+		c.Assert(err, gc.IsNil)
+	}
+
+	// Once the generation is recorded again, it carries on from there.
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, last+1)
+}
+
+// generationFilesystem is a fslock.Filesystem that works on disk, but
+// can't move a lock's generation file into place.
+type generationFilesystem struct {
+	fslock.OSFilesystem
+}
+
+func (fs generationFilesystem) Rename(oldname, newname string) error {
+	if strings.HasSuffix(newname, ".generation") {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EACCES}
+	}
+	return fs.OSFilesystem.Rename(oldname, newname)
+}
+
+func (s *fslockSuite) TestGenerationNotRecorded(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithFilesystem(generationFilesystem{}))
+	c.Assert(err, gc.IsNil)
+
+	// The lock is still acquired, and no temporary file is left behind.
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	assertGeneration(c, lock, 1)
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	for _, info := range infos {
+		c.Assert(strings.HasPrefix(info.Name(), ".testing.generation"), gc.Equals, false, gc.Commentf("%s left behind", info.Name()))
+	}
+}
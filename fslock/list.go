@@ -0,0 +1,49 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// List returns information about all the locks currently held in the
+// given lock directory, ordered by name. Locks that exist but whose
+// holder information can't be read yet are reported with Incomplete
+// set.
+func List(lockDir string) ([]LockInfo, error) {
+	entries, err := ioutil.ReadDir(lockDir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []LockInfo
+	for _, entry := range entries {
+		// Temporary directories and files alongside the locks all
+		// have names starting with ".".
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		lock := &Lock{
+			name:   entry.Name(),
+			parent: lockDir,
+			clock:  wallClock{},
+			fs:     OSFilesystem{},
+		}
+		info, err := lock.Holder()
+		switch err {
+		case nil:
+			infos = append(infos, *info)
+		case ErrIncompleteLock:
+			infos = append(infos, LockInfo{
+				Name:       entry.Name(),
+				Incomplete: true,
+			})
+		case ErrLockNotHeld:
+			// The lock was released after the directory was read.
+		default:
+			return nil, err
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestList(c *gc.C) {
+	dir := c.MkDir()
+	for _, name := range []string{"charlie", "alpha", "bravo"} {
+		lock, err := fslock.NewLock(dir, name)
+		c.Assert(err, gc.IsNil)
+		err = lock.Lock("")
+		c.Assert(err, gc.IsNil)
+	}
+	shared, err := fslock.NewLock(dir, "delta")
+	c.Assert(err, gc.IsNil)
+	err = shared.LockShared("")
+	c.Assert(err, gc.IsNil)
+	// Locks that aren't held aren't listed.
+	_, err = fslock.NewLock(dir, "echo")
+	c.Assert(err, gc.IsNil)
+	// Nor are other things in the lock directory.
+	err = ioutil.WriteFile(path.Join(dir, "file"), []byte("foo"), 0644)
+	c.Assert(err, gc.IsNil)
+	err = os.Mkdir(path.Join(dir, ".hidden"), 0755)
+	c.Assert(err, gc.IsNil)
+
+	infos, err := fslock.List(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 4)
+	for i, name := range []string{"alpha", "bravo", "charlie", "delta"} {
+		c.Check(infos[i].Name, gc.Equals, name)
+		c.Check(infos[i].Incomplete, gc.Equals, false)
+		c.Check(infos[i].Shared, gc.Equals, name == "delta")
+		c.Check(infos[i].PID, gc.Equals, os.Getpid())
+	}
+}
+
+func (s *fslockSuite) TestListIncomplete(c *gc.C) {
+	dir := c.MkDir()
+	err := os.Mkdir(path.Join(dir, "testing"), 0755)
+	c.Assert(err, gc.IsNil)
+
+	infos, err := fslock.List(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.DeepEquals, []fslock.LockInfo{{
+		Name:       "testing",
+		Incomplete: true,
+	}})
+}
+
+func (s *fslockSuite) TestListEmpty(c *gc.C) {
+	infos, err := fslock.List(c.MkDir())
+	c.Assert(err, gc.IsNil)
+	c.Assert(infos, gc.HasLen, 0)
+}
@@ -0,0 +1,45 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+// Logger is told about events in the life of a lock: attempts to
+// acquire it, and its acquisition, release and breaking. It is separate
+// from the package's own logging, so that the events can be sent to
+// wherever the caller keeps its structured logs. Warnings, such as those
+// from WithWatchdog, are logged with a Warningf method if the logger has
+// one, with the same signature as Debugf.
+type Logger interface {
+	// Debugf logs a message formatted as by fmt.Sprintf.
+	Debugf(format string, args ...interface{})
+}
+
+// WithLogger makes the lock log its lifecycle events to the given
+// logger. Without one, nothing is logged and no work is done to
+// describe the events.
+func WithLogger(log Logger) Option {
+	return func(lock *Lock) {
+		lock.log = log
+	}
+}
+
+// debugf logs to the lock's logger, if it has one.
+func (lock *Lock) debugf(format string, args ...interface{}) {
+	if lock.log != nil {
+		lock.log.Debugf(format, args...)
+	}
+}
+
+// debugHolder logs who is holding the lock, for when an attempt to
+// acquire it has failed.
+func (lock *Lock) debugHolder(attempt int) {
+	if lock.log == nil {
+		return
+	}
+	holder, err := lock.Holder()
+	if err != nil {
+		lock.debugf("lock %q: attempt %d failed: %v", lock.name, attempt, err)
+		return
+	}
+	lock.debugf("lock %q: attempt %d failed: held by pid %d on %q since %s", lock.name, attempt, holder.PID, holder.Hostname, holder.AcquiredAt)
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"fmt"
+	"sync"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// recordingLogger is a fslock.Logger that records what is logged.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (log *recordingLogger) Debugf(format string, args ...interface{}) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.lines = append(log.lines, fmt.Sprintf(format, args...))
+}
+
+func (s *fslockSuite) TestLoggerLifecycle(c *gc.C) {
+	dir := c.MkDir()
+	log := &recordingLogger{}
+	lock1, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log))
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("first")
+	c.Assert(err, gc.IsNil)
+	c.Assert(log.lines, gc.HasLen, 1)
+	c.Assert(log.lines[0], gc.Matches, `lock "testing": acquired after .* with message "first"`)
+
+	log.lines = nil
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(log.lines[0], gc.Matches, `lock "testing": attempt 0 failed: held by pid [0-9]+ on ".*" since .*`)
+	c.Assert(log.lines[len(log.lines)-1], gc.Equals, `lock "testing": gave up: lock timeout exceeded`)
+
+	log.lines = nil
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(log.lines, gc.DeepEquals, []string{`lock "testing": unlocked`})
+
+	log.lines = nil
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	log.lines = nil
+	err = lock2.BreakLock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(log.lines, gc.HasLen, 1)
+	c.Assert(log.lines[0], gc.Matches, `lock "testing": broken, was held by pid [0-9]+ on ".*" since .*`)
+}
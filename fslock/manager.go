@@ -0,0 +1,77 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"sort"
+	"sync"
+)
+
+// Manager hands out locks in a single lock directory, returning the same
+// Lock every time a given name is asked for, so that there is one nonce
+// per lock per process. This lets all the code in a process share a
+// reentrant lock, or release everything it holds at once. It is safe to
+// use from multiple goroutines.
+type Manager struct {
+	lockDir string
+	options []Option
+
+	mu    sync.Mutex
+	locks map[string]*Lock
+}
+
+// NewManager returns a manager for the locks in the given lock
+// directory. The options are applied to every lock it creates.
+func NewManager(lockDir string, options ...Option) *Manager {
+	return &Manager{
+		lockDir: lockDir,
+		options: options,
+		locks:   make(map[string]*Lock),
+	}
+}
+
+// Get returns the lock with the given name, creating it with NewLock,
+// without acquiring it, the first time it is asked for.
+func (m *Manager) Get(name string) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lock, ok := m.locks[name]; ok {
+		return lock, nil
+	}
+	lock, err := NewLock(m.lockDir, name, m.options...)
+	if err != nil {
+		return nil, err
+	}
+	m.locks[name] = lock
+	return lock, nil
+}
+
+// UnlockAll releases every lock handed out by the manager that is
+// currently held, however many times a reentrant lock was acquired. It
+// carries on after failing to release a lock, and returns the first
+// error encountered.
+func (m *Manager) UnlockAll() error {
+	m.mu.Lock()
+	locks := make([]*Lock, 0, len(m.locks))
+	for _, lock := range m.locks {
+		locks = append(locks, lock)
+	}
+	m.mu.Unlock()
+
+	// Release the locks in the opposite order to LockAll.
+	sort.Sort(sort.Reverse(byPath(locks)))
+	var firstErr error
+	for _, lock := range locks {
+		for lock.IsLockHeld() {
+			if err := lock.Unlock(); err != nil {
+				logger.Warningf("cannot release lock %q: %v", lock.name, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				break
+			}
+		}
+	}
+	return firstErr
+}
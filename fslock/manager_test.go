@@ -0,0 +1,87 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"sync"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestManagerGet(c *gc.C) {
+	manager := fslock.NewManager(c.MkDir())
+	lock1, err := manager.Get("testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := manager.Get("testing")
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock2, gc.Equals, lock1)
+
+	other, err := manager.Get("other")
+	c.Assert(err, gc.IsNil)
+	c.Assert(other, gc.Not(gc.Equals), lock1)
+
+	_, err = manager.Get("NoCapitals")
+	c.Assert(err, gc.ErrorMatches, "Invalid lock name .*")
+}
+
+func (s *fslockSuite) TestManagerGetConcurrent(c *gc.C) {
+	manager := fslock.NewManager(c.MkDir())
+	const getters = 10
+	locks := make([]*fslock.Lock, getters)
+	var wg sync.WaitGroup
+	for i := range locks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := manager.Get("testing")
+			c.Check(err, gc.IsNil)
+			locks[i] = lock
+		}(i)
+	}
+	wg.Wait()
+	for _, lock := range locks {
+		c.Assert(lock, gc.Equals, locks[0])
+	}
+}
+
+func (s *fslockSuite) TestManagerReentrancy(c *gc.C) {
+	manager := fslock.NewManager(c.MkDir(), fslock.WithReentrancy())
+	lock1, err := manager.Get("testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := manager.Get("testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.IsNil)
+	err = lock2.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestManagerUnlockAll(c *gc.C) {
+	dir := c.MkDir()
+	manager := fslock.NewManager(dir, fslock.WithReentrancy())
+	exclusive, err := manager.Get("exclusive")
+	c.Assert(err, gc.IsNil)
+	shared, err := manager.Get("shared")
+	c.Assert(err, gc.IsNil)
+	_, err = manager.Get("unused")
+	c.Assert(err, gc.IsNil)
+
+	err = exclusive.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = exclusive.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = shared.LockShared("")
+	c.Assert(err, gc.IsNil)
+
+	err = manager.UnlockAll()
+	c.Assert(err, gc.IsNil)
+	c.Assert(exclusive.IsLocked(), gc.Equals, false)
+	c.Assert(shared.IsLocked(), gc.Equals, false)
+}
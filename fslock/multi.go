@@ -0,0 +1,58 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// LockAll blocks until it has acquired all the given locks exclusively,
+// and returns a function that releases them again. The locks are always
+// acquired in the same order, sorted by name and then by lock
+// directory, so that any number of callers can use LockAll on
+// overlapping sets of locks without deadlocking. If the context is done
+// or any lock can't be acquired, the locks already taken are released
+// and the error is returned. The returned function unlocks the locks in
+// the reverse order, logging any failures. See `Lock` for information
+// about the message.
+func LockAll(ctx context.Context, message string, locks ...*Lock) (release func(), err error) {
+	sorted := make([]*Lock, len(locks))
+	copy(sorted, locks)
+	sort.Sort(byPath(sorted))
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].lockDir() == sorted[i-1].lockDir() {
+			return nil, fmt.Errorf("lock %q given more than once", sorted[i].lockDir())
+		}
+	}
+	var taken []*Lock
+	release = func() {
+		for i := len(taken) - 1; i >= 0; i-- {
+			if err := taken[i].Unlock(); err != nil {
+				logger.Warningf("cannot release lock %q: %v", taken[i].name, err)
+			}
+		}
+	}
+	for _, lock := range sorted {
+		if err := lock.LockContext(ctx, message); err != nil {
+			release()
+			return nil, err
+		}
+		taken = append(taken, lock)
+	}
+	return release, nil
+}
+
+// byPath sorts locks by name, and then by lock directory.
+type byPath []*Lock
+
+func (l byPath) Len() int      { return len(l) }
+func (l byPath) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l byPath) Less(i, j int) bool {
+	if l[i].name != l[j].name {
+		return l[i].name < l[j].name
+	}
+	return l[i].lockDir() < l[j].lockDir()
+}
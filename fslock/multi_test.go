@@ -0,0 +1,95 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// newLocks returns a new lock for each of the given names in dir.
+func newLocks(c *gc.C, dir string, names ...string) []*fslock.Lock {
+	var locks []*fslock.Lock
+	for _, name := range names {
+		lock, err := fslock.NewLock(dir, name)
+		c.Assert(err, gc.IsNil)
+		locks = append(locks, lock)
+	}
+	return locks
+}
+
+func (s *fslockSuite) TestLockAll(c *gc.C) {
+	locks := newLocks(c, c.MkDir(), "charlie", "alpha", "bravo")
+
+	release, err := fslock.LockAll(context.Background(), "all", locks...)
+	c.Assert(err, gc.IsNil)
+	for _, lock := range locks {
+		c.Assert(lock.IsLockHeld(), gc.Equals, true)
+		c.Assert(lock.Message(), gc.Equals, "all")
+	}
+
+	release()
+	for _, lock := range locks {
+		c.Assert(lock.IsLocked(), gc.Equals, false)
+	}
+}
+
+func (s *fslockSuite) TestLockAllReleasesOnFailure(c *gc.C) {
+	dir := c.MkDir()
+	locks := newLocks(c, dir, "alpha", "bravo", "charlie")
+	other := newLocks(c, dir, "bravo")[0]
+	err := other.Lock("")
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	_, err = fslock.LockAll(ctx, "", locks...)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	for _, lock := range locks {
+		c.Assert(lock.IsLockHeld(), gc.Equals, false)
+	}
+	c.Assert(other.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockAllDuplicate(c *gc.C) {
+	dir := c.MkDir()
+	locks := newLocks(c, dir, "alpha", "alpha")
+
+	_, err := fslock.LockAll(context.Background(), "", locks...)
+	c.Assert(err, gc.ErrorMatches, `lock ".*/alpha" given more than once`)
+	c.Assert(locks[0].IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestLockAllNoDeadlock(c *gc.C) {
+	const lockAttempts = 20
+	dir := c.MkDir()
+	done := make(chan error, 2)
+	lockAll := func(names ...string) {
+		locks := newLocks(c, dir, names...)
+		for i := 0; i < lockAttempts; i++ {
+			release, err := fslock.LockAll(context.Background(), "", locks...)
+			if err != nil {
+				done <- err
+				return
+			}
+			release()
+		}
+		done <- nil
+	}
+	// Taking the locks in the order given would deadlock.
+	go lockAll("alpha", "bravo")
+	go lockAll("bravo", "alpha")
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			c.Assert(err, gc.IsNil)
+		case <-time.After(longWait):
+			c.Fatalf("Deadlocked")
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import "time"
+
+// Observer is told about attempts to acquire a lock, so that metrics
+// such as how long acquisitions take can be recorded. Its methods are
+// called synchronously by the goroutine acquiring the lock, and so
+// should return quickly.
+type Observer interface {
+	// OnAcquireStart is called when the receiver starts trying to
+	// acquire the lock.
+	OnAcquireStart()
+
+	// OnContended is called, at most once per acquisition, when the
+	// first attempt to acquire the lock finds it held by someone
+	// else.
+	OnContended()
+
+	// OnAcquireSuccess is called when the lock has been acquired, with
+	// how long it took.
+	OnAcquireSuccess(waited time.Duration)
+}
+
+// nopObserver is the Observer used by locks unless WithObserver is
+// used.
+type nopObserver struct{}
+
+func (nopObserver) OnAcquireStart()                       {}
+func (nopObserver) OnContended()                          {}
+func (nopObserver) OnAcquireSuccess(waited time.Duration) {}
+
+// WithObserver makes the lock tell the given observer about attempts to
+// acquire it.
+func WithObserver(observer Observer) Option {
+	return func(lock *Lock) {
+		lock.observer = observer
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"sync"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// recordingObserver is a fslock.Observer that records the calls made to
+// it.
+type recordingObserver struct {
+	mu     sync.Mutex
+	calls  []string
+	waited []time.Duration
+}
+
+func (o *recordingObserver) record(call string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, call)
+}
+
+func (o *recordingObserver) OnAcquireStart() {
+	o.record("start")
+}
+
+func (o *recordingObserver) OnContended() {
+	o.record("contended")
+}
+
+func (o *recordingObserver) OnAcquireSuccess(waited time.Duration) {
+	o.record("success")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.waited = append(o.waited, waited)
+}
+
+func (s *fslockSuite) TestObserverUncontended(c *gc.C) {
+	observer := &recordingObserver{}
+	lock, err := fslock.NewLock(c.MkDir(), "testing", fslock.WithObserver(observer))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(observer.calls, gc.DeepEquals, []string{"start", "success"})
+}
+
+func (s *fslockSuite) TestObserverContended(c *gc.C) {
+	dir := c.MkDir()
+	clock := &fakeClock{now: time.Now()}
+	observer := &recordingObserver{}
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing",
+		fslock.WithObserver(observer),
+		fslock.WithClock(clock),
+		fslock.WithRetryDelay(time.Second),
+	)
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock2.LockWithTimeout(3*time.Second, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	c.Assert(observer.calls, gc.DeepEquals, []string{"start", "contended"})
+
+	observer.calls = nil
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	time.Sleep(shortWait)
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(observer.calls, gc.DeepEquals, []string{"start", "contended", "success"})
+	c.Assert(observer.waited, gc.HasLen, 1)
+	c.Assert(observer.waited[0] > 0, gc.Equals, true)
+}
@@ -0,0 +1,475 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"time"
+)
+
+// A lock held for shared use is represented by a lock directory whose
+// held information is marked as shared, containing a readers file which
+// lists everyone holding it. Changes to the readers file are serialised
+// by a guard directory alongside the lock directory, which is only held
+// for as long as it takes to read and rewrite the readers file.
+
+const (
+	// guardWaitDelay is how long to wait between attempts to take the
+	// guard of a shared lock.
+	guardWaitDelay = 10 * time.Millisecond
+
+	// staleGuardAge is how old a guard must be before it is assumed to
+	// have been left behind by a process that died while holding it.
+	staleGuardAge = time.Minute
+)
+
+// ErrUpgradeConflict is returned by Upgrade when another holder of the
+// shared lock is already waiting to upgrade it. Only one of them can
+// succeed without the other releasing its shared lock.
+var ErrUpgradeConflict = errors.New("lock upgrade conflicts with another upgrade")
+
+// errGuardAborted is returned by guard when its abort channel is closed
+// while it is waiting.
+var errGuardAborted = errors.New("wait for lock guard aborted")
+
+// readerInfo describes a holder of a shared lock.
+type readerInfo struct {
+	Nonce    []byte `json:"nonce"`
+	PID      int    `json:"pid,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+
+	// Upgrading is set while the reader is waiting to upgrade the lock
+	// to exclusive use.
+	Upgrading bool `json:"upgrading,omitempty"`
+}
+
+// newReaderInfo returns the reader information describing the receiver.
+func (lock *Lock) newReaderInfo() readerInfo {
+	hostname, _ := os.Hostname()
+	return readerInfo{
+		Nonce:    lock.nonce,
+		PID:      os.Getpid(),
+		Hostname: hostname,
+	}
+}
+
+// readers holds the contents of the readers file of a shared lock.
+type readers []readerInfo
+
+// index returns the position of the reader with the given nonce, or -1
+// if there is no such reader.
+func (r readers) index(nonce []byte) int {
+	for i, reader := range r {
+		if bytes.Equal(reader.Nonce, nonce) {
+			return i
+		}
+	}
+	return -1
+}
+
+// upgrading returns whether any reader is waiting to upgrade the lock.
+func (r readers) upgrading() bool {
+	for _, reader := range r {
+		if reader.Upgrading {
+			return true
+		}
+	}
+	return false
+}
+
+// LockShared blocks until it is able to acquire the lock for shared use.
+// Any number of holders may share the lock at the same time, but not
+// while it is held exclusively. Shared holders release the lock with
+// Unlock. See `Lock` for information about the message.
+func (lock *Lock) LockShared(message string) error {
+	continueFunc := func() error { return nil }
+	return lock.lockLoop(message, lock.tryShared(message, nil), continueFunc, nil)
+}
+
+// LockExclusive blocks until it is able to acquire the lock for
+// exclusive use. It waits for all shared holders to release the lock.
+// It is the same as Lock.
+func (lock *Lock) LockExclusive(message string) error {
+	return lock.Lock(message)
+}
+
+// tryShared returns a function that makes a single attempt to acquire
+// the lock for shared use, for use with lockLoop. Waiting for the guard
+// is given up when abort is closed.
+func (lock *Lock) tryShared(message string, abort <-chan struct{}) func() (bool, error) {
+	return func() (bool, error) {
+		return lock.acquireShared(message, abort)
+	}
+}
+
+// acquireShared makes a single attempt to acquire the lock for shared
+// use. If nobody holds the lock, it is taken, and the message saved with
+// it. Otherwise, if it is already shared, the receiver joins the
+// readers.
+func (lock *Lock) acquireShared(message string, abort <-chan struct{}) (bool, error) {
+	unguard, err := lock.guard(abort)
+	if err != nil {
+		return false, err
+	}
+	defer unguard()
+
+	info, err := lock.readHeld()
+	switch err {
+	case nil:
+	case ErrLockNotHeld:
+		info = lock.newHeldInfo()
+		info.Shared = true
+		info.Message = message
+		return lock.acquireWith(info)
+	case ErrIncompleteLock:
+		return false, nil
+	default:
+		return false, err
+	}
+	if !info.Shared {
+		return false, nil
+	}
+	current, err := lock.readReaders()
+	if err != nil {
+		return false, err
+	}
+	if current.index(lock.nonce) >= 0 {
+		return true, nil
+	}
+	if current.upgrading() {
+		// Let the upgrade go ahead rather than starving it.
+		return false, nil
+	}
+	current = append(current, lock.newReaderInfo())
+	if err := lock.writeReaders(current); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// unlockShared removes the receiver from the readers of a shared lock,
+// releasing the lock altogether if it was the last one.
+func (lock *Lock) unlockShared() error {
+	unguard, err := lock.guard(nil)
+	if err != nil {
+		return err
+	}
+	defer unguard()
+
+	current, err := lock.readReaders()
+	if err != nil {
+		return ErrLockNotHeld
+	}
+	i := current.index(lock.nonce)
+	if i < 0 {
+		return ErrLockNotHeld
+	}
+	current = append(current[:i], current[i+1:]...)
+	if len(current) == 0 {
+		return lock.releaseShared()
+	}
+	return lock.writeReaders(current)
+}
+
+// releaseShared releases a shared lock once it has no readers left. It
+// must be called with the guard held, so the lock can't change hands
+// meanwhile, except by being broken.
+func (lock *Lock) releaseShared() error {
+	info, err := lock.readHeld()
+	if err != nil {
+		return ErrLockNotHeld
+	}
+	return lock.release(info.Nonce)
+}
+
+// Upgrade blocks until it is able to convert a lock held for shared
+// use by the receiver into one held exclusively, without releasing it in
+// between. This means waiting for all other shared holders to release
+// the lock; no new shared holders are admitted in the meantime. If
+// another holder is already waiting to upgrade the lock,
+// ErrUpgradeConflict is returned, as neither could ever succeed. If the
+// context is done first, the lock is left shared, and the context's
+// error is returned. If the receiver doesn't hold the lock for shared
+// use, ErrLockNotHeld is returned.
+func (lock *Lock) Upgrade(ctx context.Context) error {
+	// Only an attempt that took the guard can have marked the receiver
+	// as waiting to upgrade.
+	guarded := false
+	try := func() (bool, error) {
+		upgraded, err := lock.upgrade(true, ctx.Done())
+		if err != errGuardAborted {
+			guarded = true
+		}
+		return upgraded, err
+	}
+	continueFunc := func() error { return ctx.Err() }
+	err := lock.lockLoop("upgrade", try, continueFunc, ctx.Done())
+	if err != nil && guarded {
+		lock.abandonUpgrade()
+	}
+	return err
+}
+
+// TryUpgrade makes a single attempt to convert a lock held for shared
+// use by the receiver into one held exclusively, and reports whether it
+// succeeded. It only succeeds if nobody else shares the lock at the
+// moment; otherwise the lock is left shared. If the receiver doesn't
+// hold the lock for shared use, ErrLockNotHeld is returned.
+func (lock *Lock) TryUpgrade() (bool, error) {
+	return lock.upgrade(false, nil)
+}
+
+// upgrade makes a single attempt to convert a shared lock held by the
+// receiver into an exclusive one. If it can't be done yet and wait is
+// true, the receiver is marked as waiting to upgrade. Waiting for the
+// guard is given up when abort is closed.
+func (lock *Lock) upgrade(wait bool, abort <-chan struct{}) (bool, error) {
+	unguard, err := lock.guard(abort)
+	if err != nil {
+		return false, err
+	}
+	defer unguard()
+
+	info, err := lock.readHeld()
+	if err != nil || !info.Shared {
+		return false, ErrLockNotHeld
+	}
+	current, err := lock.readReaders()
+	if err != nil {
+		return false, ErrLockNotHeld
+	}
+	i := current.index(lock.nonce)
+	if i < 0 {
+		return false, ErrLockNotHeld
+	}
+	if len(current) == 1 {
+		// The held information is changed first, so that anyone who
+		// sees the lock as shared also sees the receiver as a reader.
+		held := lock.newHeldInfo()
+		held.Message = info.Message
+		held.Generation = info.Generation
+		if err := lock.writeHeld(held); err != nil {
+			return false, err
+		}
+		return true, lock.fs.RemoveAll(lock.readersFile())
+	}
+	if !wait || current[i].Upgrading {
+		return false, nil
+	}
+	if current.upgrading() {
+		return false, ErrUpgradeConflict
+	}
+	current[i].Upgrading = true
+	return false, lock.writeReaders(current)
+}
+
+// abandonUpgrade clears the mark saying that the receiver is waiting to
+// upgrade the lock.
+func (lock *Lock) abandonUpgrade() {
+	unguard, err := lock.guard(nil)
+	if err != nil {
+		return
+	}
+	defer unguard()
+
+	current, err := lock.readReaders()
+	if err != nil {
+		return
+	}
+	if i := current.index(lock.nonce); i >= 0 && current[i].Upgrading {
+		current[i].Upgrading = false
+		if err := lock.writeReaders(current); err != nil {
+			logger.Warningf("cannot abandon upgrade of lock %q: %v", lock.name, err)
+		}
+	}
+}
+
+// Downgrade converts a lock held exclusively by the receiver into one
+// held for shared use, without releasing it in between. If the receiver
+// doesn't hold the lock exclusively, ErrLockNotHeld is returned.
+func (lock *Lock) Downgrade() error {
+	unguard, err := lock.guard(nil)
+	if err != nil {
+		return err
+	}
+	defer unguard()
+
+	info, err := lock.readHeld()
+	if err != nil || info.Shared || !bytes.Equal(info.Nonce, lock.nonce) {
+		return ErrLockNotHeld
+	}
+	// The readers are written first, so that anyone who sees the lock
+	// as shared also sees the receiver as a reader.
+	if err := lock.writeReaders(readers{lock.newReaderInfo()}); err != nil {
+		return err
+	}
+	info.Shared = true
+	info.Expires = nil
+	return lock.writeHeld(info)
+}
+
+// ReaderCount returns how many holders share the lock. It returns zero
+// if the lock isn't held, or is held exclusively. Each reader is
+// recorded with its process id and hostname, so that readers left behind
+// by processes that died can be found.
+func (lock *Lock) ReaderCount() (int, error) {
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !info.Shared {
+		return 0, nil
+	}
+	current, err := lock.readReaders()
+	if err != nil {
+		return 0, err
+	}
+	return len(current), nil
+}
+
+// PruneDeadReaders removes the holders of a shared lock that were
+// processes on this machine that no longer exist, releasing the lock if
+// nobody is left holding it, and returns how many it removed. Readers on
+// other machines are left alone. Those waiting to acquire a shared lock
+// prune it themselves, so this is only needed for maintenance.
+func (lock *Lock) PruneDeadReaders() (int, error) {
+	return lock.pruneDeadReaders(nil)
+}
+
+// pruneDeadReaders implements PruneDeadReaders, giving up waiting for
+// the guard when abort is closed.
+func (lock *Lock) pruneDeadReaders(abort <-chan struct{}) (int, error) {
+	info, err := lock.readHeld()
+	if err == ErrLockNotHeld || err == ErrIncompleteLock {
+		return 0, nil
+	}
+	if err != nil || !info.Shared {
+		return 0, err
+	}
+	unguard, err := lock.guard(abort)
+	if err != nil {
+		return 0, err
+	}
+	defer unguard()
+
+	current, err := lock.readReaders()
+	if os.IsNotExist(err) {
+		// The lock was released in the meantime.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	hostname, _ := os.Hostname()
+	var alive readers
+	for _, reader := range current {
+		if reader.Hostname == hostname && reader.PID > 0 && !processExists(reader.PID) {
+			logger.Infof("removing reader of lock %q with pid %d, which no longer exists", lock.name, reader.PID)
+			continue
+		}
+		alive = append(alive, reader)
+	}
+	removed := len(current) - len(alive)
+	if removed == 0 {
+		return 0, nil
+	}
+	if len(alive) == 0 {
+		return removed, lock.releaseShared()
+	}
+	return removed, lock.writeReaders(alive)
+}
+
+func (lock *Lock) readersFile() string {
+	return path.Join(lock.lockDir(), readersFilename)
+}
+
+// readReaders reads the readers file of a shared lock.
+func (lock *Lock) readReaders() (readers, error) {
+	data, err := lock.fs.ReadFile(lock.readersFile())
+	if err != nil {
+		return nil, err
+	}
+	var current readers
+	if err := json.Unmarshal(data, &current); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// writeReaders replaces the readers file of a shared lock.
+func (lock *Lock) writeReaders(current readers) error {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return lock.replaceFile(readersFilename, data)
+}
+
+// guard blocks until it has taken the guard protecting the readers of
+// the lock, and returns a function that releases it. If abort is closed
+// first, errGuardAborted is returned. The guard is only ever held for a
+// moment, so it is polled using the system clock rather than the
+// lock's, which may be a testing clock that isn't being advanced.
+func (lock *Lock) guard(abort <-chan struct{}) (func(), error) {
+	guardDir := lock.guardDir()
+	for {
+		err := lock.fs.Mkdir(guardDir, lock.dirMode)
+		if err == nil {
+			return func() { lock.fs.RemoveAll(guardDir) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := lock.fs.Stat(guardDir); err == nil && olderThan(info, staleGuardAge) {
+			if err := lock.removeStaleGuard(info); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		select {
+		case <-abort:
+			return nil, errGuardAborted
+		case <-time.After(guardWaitDelay):
+		}
+	}
+}
+
+func (lock *Lock) guardDir() string {
+	return lock.hiddenName("guard")
+}
+
+// removeStaleGuard removes the guard of the lock, found to be stale with
+// the given information. Someone else may have removed it and taken the
+// guard since, so it is first moved aside, and put back unless it is the
+// same directory that was found to be stale.
+func (lock *Lock) removeStaleGuard(stale os.FileInfo) error {
+	aside := lock.hiddenName(fmt.Sprintf("guard.%x%d", lock.nonce, rand.Uint32()))
+	if err := lock.fs.Rename(lock.guardDir(), aside); err != nil {
+		if os.IsNotExist(err) {
+			// Someone else removed it first.
+			return nil
+		}
+		return err
+	}
+	info, err := lock.fs.Stat(aside)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, stale) {
+		// The stale guard was replaced by a new one; put it back.
+		return lock.fs.Rename(aside, lock.guardDir())
+	}
+	logger.Infof("removing stale guard for lock %q", lock.name)
+	return lock.fs.RemoveAll(aside)
+}
@@ -0,0 +1,603 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestLockSharedMultipleReaders(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writer, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("reading")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(reader1.IsLockHeld(), gc.Equals, true)
+	c.Assert(reader2.IsLockHeld(), gc.Equals, true)
+	c.Assert(writer.IsLockHeld(), gc.Equals, false)
+	c.Assert(writer.IsLocked(), gc.Equals, true)
+	c.Assert(writer.Message(), gc.Equals, "reading")
+
+	err = writer.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+
+	err = reader1.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(reader1.IsLockHeld(), gc.Equals, false)
+	c.Assert(reader2.IsLockHeld(), gc.Equals, true)
+	err = writer.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(writer.IsLocked(), gc.Equals, false)
+	err = writer.LockExclusive("")
+	c.Assert(err, gc.IsNil)
+	c.Assert(writer.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestReaderCount(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	assertReaders := func(expect int) {
+		count, err := reader1.ReaderCount()
+		c.Assert(err, gc.IsNil)
+		c.Assert(count, gc.Equals, expect)
+	}
+	assertReaders(0)
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	assertReaders(1)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+	assertReaders(2)
+
+	// Each reader is recorded along with its process.
+	data, err := ioutil.ReadFile(path.Join(dir, "testing", "readers"))
+	c.Assert(err, gc.IsNil)
+	var readers []map[string]interface{}
+	err = json.Unmarshal(data, &readers)
+	c.Assert(err, gc.IsNil)
+	c.Assert(readers, gc.HasLen, 2)
+	for _, reader := range readers {
+		c.Assert(reader["pid"], gc.Equals, float64(os.Getpid()))
+	}
+
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	assertReaders(1)
+	err = reader1.Upgrade(context.Background())
+	c.Assert(err, gc.IsNil)
+	assertReaders(0)
+}
+
+// addReader adds a reader with the given process and hostname to the
+// readers of the named shared lock.
+func addReader(c *gc.C, dir, name string, pid int, hostname string) {
+	file := path.Join(dir, name, "readers")
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, gc.IsNil)
+	var readers []map[string]interface{}
+	err = json.Unmarshal(data, &readers)
+	c.Assert(err, gc.IsNil)
+	readers = append(readers, map[string]interface{}{
+		"nonce":    []byte(fmt.Sprintf("reader%d", pid)),
+		"pid":      pid,
+		"hostname": hostname,
+	})
+	data, err = json.Marshal(readers)
+	c.Assert(err, gc.IsNil)
+	err = ioutil.WriteFile(file, data, 0644)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *fslockSuite) TestPruneDeadReaders(c *gc.C) {
+	dir := c.MkDir()
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+
+	removed, err := reader.PruneDeadReaders()
+	c.Assert(err, gc.IsNil)
+	c.Assert(removed, gc.Equals, 0)
+
+	err = reader.LockShared("")
+	c.Assert(err, gc.IsNil)
+	addReader(c, dir, "testing", deadPID(c), hostname)
+	addReader(c, dir, "testing", deadPID(c), "some.other.host")
+	removed, err = reader.PruneDeadReaders()
+	c.Assert(err, gc.IsNil)
+	c.Assert(removed, gc.Equals, 1)
+	count, err := reader.ReaderCount()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 2)
+	c.Assert(reader.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockExclusivePrunesDeadReaders(c *gc.C) {
+	dir := c.MkDir()
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writer, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+	hostname, err := os.Hostname()
+	c.Assert(err, gc.IsNil)
+
+	// The live reader is the only thing keeping the lock from the
+	// writer.
+	err = reader.LockShared("")
+	c.Assert(err, gc.IsNil)
+	addReader(c, dir, "testing", deadPID(c), hostname)
+	err = reader.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(reader.IsLocked(), gc.Equals, true)
+
+	err = writer.LockWithTimeout(longWait, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(writer.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestUnlockSharedNotHeld(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.Unlock()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	c.Assert(reader1.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestLockSharedBlocksOnWriter(c *gc.C) {
+	dir := c.MkDir()
+	writer, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = writer.LockExclusive("")
+	c.Assert(err, gc.IsNil)
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- reader.LockShared("")
+	}()
+
+	select {
+	case <-acquired:
+		c.Fatalf("Unexpected lock acquisition")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = writer.Unlock()
+	c.Assert(err, gc.IsNil)
+
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(reader.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestUpgradeSoleReader(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("reading")
+	c.Assert(err, gc.IsNil)
+	err = lock.Upgrade(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	c.Assert(reader.Message(), gc.Equals, "reading")
+
+	// Nobody can share the lock now.
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- reader.LockShared("")
+	}()
+	select {
+	case <-acquired:
+		c.Fatalf("Unexpected lock acquisition")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = lock.Downgrade()
+	c.Assert(err, gc.IsNil)
+	c.Assert(lock.IsLockHeld(), gc.Equals, true)
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(reader.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestTryUpgrade(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	upgraded, err := reader1.TryUpgrade()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	c.Assert(upgraded, gc.Equals, false)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+	upgraded, err = reader1.TryUpgrade()
+	c.Assert(err, gc.IsNil)
+	c.Assert(upgraded, gc.Equals, false)
+	c.Assert(reader1.IsLockHeld(), gc.Equals, true)
+	c.Assert(reader2.IsLockHeld(), gc.Equals, true)
+
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	upgraded, err = reader1.TryUpgrade()
+	c.Assert(err, gc.IsNil)
+	c.Assert(upgraded, gc.Equals, true)
+	err = reader2.LockWithTimeout(shortWait, "")
+	c.Assert(err, gc.Equals, fslock.ErrTimeout)
+	count, err := reader1.ReaderCount()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+}
+
+func (s *fslockSuite) TestTryUpgradeConcurrentReaders(c *gc.C) {
+	const lockAttempts = 20
+	const concurrentLocks = 5
+	dir := c.MkDir()
+
+	// readers counts the shared holders, and writers those that have
+	// upgraded; there must never be a writer at the same time as
+	// anyone else.
+	var readers, writers int32
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentLocks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+			c.Check(err, gc.IsNil)
+			for j := 0; j < lockAttempts; j++ {
+				c.Check(lock.LockShared(""), gc.IsNil)
+				atomic.AddInt32(&readers, 1)
+				upgraded, err := lock.TryUpgrade()
+				c.Check(err, gc.IsNil)
+				if upgraded {
+					c.Check(atomic.AddInt32(&writers, 1), gc.Equals, int32(1))
+					c.Check(atomic.LoadInt32(&readers), gc.Equals, int32(1))
+					atomic.AddInt32(&writers, -1)
+				}
+				atomic.AddInt32(&readers, -1)
+				c.Check(lock.Unlock(), gc.IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *fslockSuite) TestUpgradeWaitsForReaders(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- reader1.Upgrade(context.Background())
+	}()
+	select {
+	case <-upgraded:
+		c.Fatalf("Unexpected upgrade")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-upgraded:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected upgrade")
+	}
+	c.Assert(reader1.IsLockHeld(), gc.Equals, true)
+	err = reader1.Unlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(reader1.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestUpgradeConflict(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- reader1.Upgrade(context.Background())
+	}()
+	time.Sleep(shortWait)
+
+	err = reader2.Upgrade(context.Background())
+	c.Assert(err, gc.Equals, fslock.ErrUpgradeConflict)
+	c.Assert(reader2.IsLockHeld(), gc.Equals, true)
+
+	err = reader2.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-upgraded:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected upgrade")
+	}
+}
+
+func (s *fslockSuite) TestUpgradeCancelled(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader3, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = reader1.Upgrade(ctx)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(reader1.IsLockHeld(), gc.Equals, true)
+
+	// Having given up, the upgrade no longer holds back new readers.
+	err = reader3.LockShared("")
+	c.Assert(err, gc.IsNil)
+}
+
+// stoppedClock is a fslock.Clock whose time never passes.
+type stoppedClock struct {
+	now time.Time
+}
+
+func (clock stoppedClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock stoppedClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+// takeGuard creates the guard of the named lock in dir, as if another
+// process were using it, and returns a function that removes it.
+func takeGuard(c *gc.C, dir, name string) func() {
+	guardDir := path.Join(dir, "."+name+".guard")
+	err := os.Mkdir(guardDir, 0700)
+	c.Assert(err, gc.IsNil)
+	return func() {
+		err := os.Remove(guardDir)
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *fslockSuite) TestUpgradeCancelledWaitingForGuard(c *gc.C) {
+	dir := c.MkDir()
+	reader1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	reader2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader1.LockShared("")
+	c.Assert(err, gc.IsNil)
+	err = reader2.LockShared("")
+	c.Assert(err, gc.IsNil)
+	unguard := takeGuard(c, dir, "testing")
+	defer unguard()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- reader1.Upgrade(ctx)
+	}()
+	select {
+	case err := <-upgraded:
+		c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	case <-time.After(longWait):
+		c.Fatalf("Upgrade not cancelled while waiting for the guard")
+	}
+}
+
+func (s *fslockSuite) TestTryLockWithCancelWaitingForGuard(c *gc.C) {
+	dir := c.MkDir()
+	reader, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	writer, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = reader.LockShared("")
+	c.Assert(err, gc.IsNil)
+	unguard := takeGuard(c, dir, "testing")
+	defer unguard()
+
+	cancel := make(chan struct{})
+	type result struct {
+		locked bool
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		locked, err := writer.TryLockWithCancel(longWait, cancel, "")
+		results <- result{locked, err}
+	}()
+	time.Sleep(shortWait)
+	close(cancel)
+	select {
+	case r := <-results:
+		c.Assert(r.err, gc.IsNil)
+		c.Assert(r.locked, gc.Equals, false)
+	case <-time.After(longWait):
+		c.Fatalf("TryLockWithCancel not cancelled while waiting for the guard")
+	}
+}
+
+func (s *fslockSuite) TestGuardWaitUsesSystemClock(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithClock(stoppedClock{time.Now()}))
+	c.Assert(err, gc.IsNil)
+
+	unguard := takeGuard(c, dir, "testing")
+	go func() {
+		time.Sleep(shortWait)
+		unguard()
+	}()
+	locked := make(chan error, 1)
+	go func() {
+		locked <- lock.LockShared("")
+	}()
+	select {
+	case err := <-locked:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("LockShared still waiting for a released guard")
+	}
+}
+
+func (s *fslockSuite) TestStaleGuardRemoved(c *gc.C) {
+	dir := c.MkDir()
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	takeGuard(c, dir, "testing")
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(path.Join(dir, ".testing.guard"), old, old)
+	c.Assert(err, gc.IsNil)
+
+	err = lock.LockShared("")
+	c.Assert(err, gc.IsNil)
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	for _, info := range infos {
+		c.Assert(strings.HasPrefix(info.Name(), ".testing.guard"), gc.Equals, false, gc.Commentf("%s left behind", info.Name()))
+	}
+}
+
+func (s *fslockSuite) TestUpgradeDowngradeNotHeld(c *gc.C) {
+	dir := c.MkDir()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Upgrade(context.Background())
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	err = lock1.Downgrade()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock1.Upgrade(context.Background())
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+	err = lock2.Downgrade()
+	c.Assert(err, gc.Equals, fslock.ErrLockNotHeld)
+}
+
+func (s *fslockSuite) TestSharedStress(c *gc.C) {
+	const lockAttempts = 50
+	const concurrentLocks = 10
+
+	// readers counts the shared holders, and writers the exclusive
+	// ones; there must never be a writer at the same time as anyone
+	// else.
+	var readers, writers int32
+	done := make(chan struct{})
+	dir := c.MkDir()
+
+	stress := func(exclusive bool) {
+		defer func() { done <- struct{}{} }()
+		lock, err := fslock.NewLock(dir, "testing")
+		if err != nil {
+			c.Errorf("Failed to create a new lock")
+			return
+		}
+		for i := 0; i < lockAttempts; i++ {
+			if exclusive {
+				err = lock.LockExclusive("")
+				c.Assert(err, gc.IsNil)
+				c.Assert(atomic.AddInt32(&writers, 1), gc.Equals, int32(1))
+				c.Assert(atomic.LoadInt32(&readers), gc.Equals, int32(0))
+				atomic.AddInt32(&writers, -1)
+			} else {
+				err = lock.LockShared("")
+				c.Assert(err, gc.IsNil)
+				atomic.AddInt32(&readers, 1)
+				c.Assert(atomic.LoadInt32(&writers), gc.Equals, int32(0))
+				atomic.AddInt32(&readers, -1)
+			}
+			err = lock.Unlock()
+			c.Assert(err, gc.IsNil)
+		}
+	}
+
+	for i := 0; i < concurrentLocks; i++ {
+		go stress(i%3 == 0)
+	}
+	for i := 0; i < concurrentLocks; i++ {
+		<-done
+	}
+}
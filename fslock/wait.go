@@ -0,0 +1,67 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"context"
+	"os"
+)
+
+// WaitUntilFree blocks until nobody holds the lock, without acquiring
+// it, or until the given context is done, in which case the context's
+// error is returned. Nothing stops the lock being taken again as soon as
+// WaitUntilFree returns. Unlike the methods that acquire the lock, it
+// doesn't break expired leases or incomplete locks, so it keeps waiting
+// for them until somebody else does. If the lock's retry strategy says to
+// stop trying first, ErrTimeout is returned.
+func (lock *Lock) WaitUntilFree(ctx context.Context) error {
+	changes, stop := lock.watch()
+	defer stop()
+	for attempt := 0; ; attempt++ {
+		free, err := lock.isFree()
+		if err != nil || free {
+			return err
+		}
+		delay, retry := lock.waitDelay(attempt)
+		if !retry {
+			return ErrTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changes:
+		case <-lock.clock.After(delay):
+		}
+	}
+}
+
+// AvailableC returns a channel which is closed once nobody holds the
+// lock, as for WaitUntilFree. The lock is watched in the background
+// until then, or until the given context is done, in which case the
+// channel is never closed. If the lock can't be checked, the error is
+// logged and the channel is never closed either, so callers waiting on
+// it should also watch the context.
+func (lock *Lock) AvailableC(ctx context.Context) <-chan struct{} {
+	available := make(chan struct{})
+	go func() {
+		err := lock.WaitUntilFree(ctx)
+		if err == nil {
+			close(available)
+			return
+		}
+		if ctx.Err() == nil {
+			logger.Warningf("cannot wait for lock %q: %v", lock.name, err)
+		}
+	}()
+	return available
+}
+
+// isFree reports whether the lock directory doesn't exist.
+func (lock *Lock) isFree() (bool, error) {
+	_, err := lock.fs.Stat(lock.lockDir())
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,146 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+func (s *fslockSuite) TestWaitUntilFree(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.WaitUntilFree(context.Background())
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	freed := make(chan error, 1)
+	go func() {
+		freed <- lock.WaitUntilFree(context.Background())
+	}()
+	select {
+	case <-freed:
+		c.Fatalf("Unexpected return while the lock is held")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = holder.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-freed:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected the lock to be free")
+	}
+	// Waiting doesn't acquire the lock.
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestWaitUntilFreeCancelled(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), shortWait)
+	defer cancel()
+	err = lock.WaitUntilFree(ctx)
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(holder.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestWaitUntilFreeWithWatcher(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	watcher := newFakeWatcher()
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithWatcher(watcher), fslock.WithRetryDelay(time.Hour))
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	freed := make(chan error, 1)
+	go func() {
+		freed <- lock.WaitUntilFree(context.Background())
+	}()
+	<-watcher.watched
+
+	err = holder.Unlock()
+	c.Assert(err, gc.IsNil)
+	watcher.notify()
+	select {
+	case err := <-freed:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected the lock to be free")
+	}
+}
+
+func (s *fslockSuite) TestAvailableC(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Millisecond))
+	c.Assert(err, gc.IsNil)
+
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	available := lock.AvailableC(context.Background())
+	select {
+	case <-available:
+		c.Fatalf("Unexpected signal while the lock is held")
+	case <-time.After(shortWait):
+		// all good
+	}
+
+	err = holder.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case <-available:
+	case <-time.After(longWait):
+		c.Fatalf("Expected the lock to be available")
+	}
+	c.Assert(lock.IsLocked(), gc.Equals, false)
+}
+
+func (s *fslockSuite) TestAvailableCCancelled(c *gc.C) {
+	dir := c.MkDir()
+	holder, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	err = holder.Lock("")
+	c.Assert(err, gc.IsNil)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	available := lock.AvailableC(ctx)
+	cancel()
+	for timeout := time.After(longWait); runtime.NumGoroutine() > before; {
+		select {
+		case <-timeout:
+			c.Fatalf("%d goroutines left behind", runtime.NumGoroutine()-before)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	select {
+	case <-available:
+		c.Fatalf("Unexpected signal after cancelling")
+	default:
+	}
+}
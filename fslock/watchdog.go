@@ -0,0 +1,84 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// warningLogger is implemented by loggers, such as those from loggo,
+// that can log warnings as well as debugging messages.
+type warningLogger interface {
+	Warningf(format string, args ...interface{})
+}
+
+// WithWatchdog makes the lock log a warning if it is still held by the
+// receiver the given duration after the receiver acquired it, along
+// with the stack trace of the goroutine that acquired it, to help find
+// code that never releases it. The warning goes to the package's log,
+// and to the lock's logger if it has one; loggers with a Warningf
+// method, as well as Debugf, have it logged with that. The watchdog is
+// stopped when the lock is unlocked. Taking the stack trace makes every
+// acquisition more expensive.
+func WithWatchdog(after time.Duration) Option {
+	return func(lock *Lock) {
+		lock.watchdog = after
+	}
+}
+
+// startWatchdog starts the lock's watchdog, if it has one and it isn't
+// running already, after the receiver has acquired the lock.
+func (lock *Lock) startWatchdog() {
+	if lock.watchdog <= 0 {
+		return
+	}
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.watchdogStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	lock.watchdogStop = stop
+	stack := debug.Stack()
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-lock.clock.After(lock.watchdog):
+		}
+		// The watchdog is finished with, so a later acquisition can
+		// start another one, even if this one is never stopped
+		// because the lock is broken or lost rather than unlocked.
+		lock.mu.Lock()
+		if lock.watchdogStop == stop {
+			lock.watchdogStop = nil
+		}
+		lock.mu.Unlock()
+		if lock.IsLockHeld() {
+			lock.warnf("lock %q still held %s after it was acquired at:\n%s", lock.name, lock.watchdog, stack)
+		}
+	}()
+}
+
+// stopWatchdog stops the lock's watchdog, if it is running.
+func (lock *Lock) stopWatchdog() {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.watchdogStop != nil {
+		close(lock.watchdogStop)
+		lock.watchdogStop = nil
+	}
+}
+
+// warnf logs a warning to the package's log and to the lock's logger,
+// if it has one.
+func (lock *Lock) warnf(format string, args ...interface{}) {
+	logger.Warningf(format, args...)
+	if log, ok := lock.log.(warningLogger); ok {
+		log.Warningf(format, args...)
+	} else {
+		lock.debugf(format, args...)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// warningLogger is a recordingLogger that also records warnings.
+type warningLogger struct {
+	recordingLogger
+	warnings chan string
+}
+
+func (log *warningLogger) Warningf(format string, args ...interface{}) {
+	log.warnings <- fmt.Sprintf(format, args...)
+}
+
+func (s *fslockSuite) TestWatchdog(c *gc.C) {
+	dir := c.MkDir()
+	log := &warningLogger{warnings: make(chan string, 1)}
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log), fslock.WithWatchdog(shortWait))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	select {
+	case warning := <-log.warnings:
+		c.Assert(warning, gc.Matches, `(?s)lock "testing" still held 50ms after it was acquired at:\n.*`)
+		// The stack trace shows where the lock was acquired.
+		c.Assert(strings.Contains(warning, "watchdog_test.go"), gc.Equals, true)
+	case <-time.After(longWait):
+		c.Fatalf("Expected a warning")
+	}
+}
+
+func (s *fslockSuite) TestWatchdogStoppedByUnlock(c *gc.C) {
+	dir := c.MkDir()
+	log := &warningLogger{warnings: make(chan string, 1)}
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log), fslock.WithWatchdog(shortWait))
+	c.Assert(err, gc.IsNil)
+
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	err = lock.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case warning := <-log.warnings:
+		c.Fatalf("Unexpected warning: %s", warning)
+	case <-time.After(2 * shortWait):
+		// all good
+	}
+}
+
+func (s *fslockSuite) TestWatchdogRestartsAfterFiring(c *gc.C) {
+	dir := c.MkDir()
+	log := &warningLogger{warnings: make(chan string, 1)}
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log), fslock.WithWatchdog(shortWait))
+	c.Assert(err, gc.IsNil)
+	breaker, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+
+	for i := 0; i < 2; i++ {
+		err = lock.Lock("")
+		c.Assert(err, gc.IsNil)
+		select {
+		case <-log.warnings:
+		case <-time.After(longWait):
+			c.Fatalf("Expected a warning after acquisition %d", i)
+		}
+		// The lock is lost without being unlocked, so nothing stops
+		// the watchdog.
+		err = breaker.BreakLock()
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *fslockSuite) TestWatchdogDebugLogger(c *gc.C) {
+	dir := c.MkDir()
+	log := &recordingLogger{}
+	clock := &fakeClock{now: time.Now()}
+	lock, err := fslock.NewLock(dir, "testing", fslock.WithLogger(log), fslock.WithClock(clock), fslock.WithWatchdog(time.Hour))
+	c.Assert(err, gc.IsNil)
+
+	// The fake clock makes the watchdog go off straight away.
+	err = lock.Lock("")
+	c.Assert(err, gc.IsNil)
+	for timeout := time.After(longWait); ; {
+		log.mu.Lock()
+		lines := append([]string(nil), log.lines...)
+		log.mu.Unlock()
+		for _, line := range lines {
+			if strings.HasPrefix(line, `lock "testing" still held 1h0m0s after it was acquired at:`) {
+				return
+			}
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("Expected a warning, got %q", lines)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock
+
+// Watcher reports changes to the directory holding a lock, so that
+// waiters can try again as soon as the lock is released rather than at
+// the next poll. This package doesn't provide an implementation, to
+// avoid depending on any particular notification library; one backed by
+// fsnotify, for instance, need only signal on every event in the
+// directory.
+type Watcher interface {
+	// Watch starts watching the named directory. A value is sent on
+	// the returned channel whenever anything in the directory may have
+	// changed; sends may be coalesced, and spurious ones are harmless.
+	// The returned function stops the watch.
+	Watch(dir string) (<-chan struct{}, func(), error)
+}
+
+// WithWatcher makes the lock use the given watcher to wake up when the
+// lock directory is removed while waiting to acquire it. The lock still
+// polls as usual, in case a change is missed, and falls back to polling
+// alone if the directory can't be watched.
+func WithWatcher(watcher Watcher) Option {
+	return func(lock *Lock) {
+		lock.watcher = watcher
+	}
+}
+
+// watch starts watching the parent directory with the lock's watcher,
+// if it has one. If the directory can't be watched, the returned channel
+// is nil, so that it never fires.
+func (lock *Lock) watch() (<-chan struct{}, func()) {
+	if lock.watcher == nil {
+		return nil, func() {}
+	}
+	changes, stop, err := lock.watcher.Watch(lock.parent)
+	if err != nil {
+		logger.Debugf("cannot watch lock directory %q, polling instead: %v", lock.parent, err)
+		return nil, func() {}
+	}
+	return changes, stop
+}
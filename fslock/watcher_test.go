@@ -0,0 +1,103 @@
+// Copyright 2026 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package fslock_test
+
+import (
+	"errors"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/utils/fslock"
+)
+
+// fakeWatcher is a Watcher whose changes are reported by calling
+// notify.
+type fakeWatcher struct {
+	watchErr error
+	changes  chan struct{}
+	watched  chan string
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		changes: make(chan struct{}, 1),
+		watched: make(chan string, 1),
+	}
+}
+
+func (w *fakeWatcher) Watch(dir string) (<-chan struct{}, func(), error) {
+	if w.watchErr != nil {
+		return nil, nil, w.watchErr
+	}
+	w.watched <- dir
+	return w.changes, func() {}, nil
+}
+
+func (w *fakeWatcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (s *fslockSuite) TestWatcherWakesWaiter(c *gc.C) {
+	dir := c.MkDir()
+	watcher := newFakeWatcher()
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithRetryDelay(time.Hour), fslock.WithWatcher(watcher))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	select {
+	case watchedDir := <-watcher.watched:
+		c.Assert(watchedDir, gc.Equals, dir)
+	case <-time.After(longWait):
+		c.Fatalf("Expected the directory to be watched")
+	}
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	watcher.notify()
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+	c.Assert(lock2.IsLockHeld(), gc.Equals, true)
+}
+
+func (s *fslockSuite) TestWatcherFailureFallsBackToPolling(c *gc.C) {
+	dir := c.MkDir()
+	watcher := newFakeWatcher()
+	watcher.watchErr = errors.New("no notifications here")
+	lock1, err := fslock.NewLock(dir, "testing")
+	c.Assert(err, gc.IsNil)
+	lock2, err := fslock.NewLock(dir, "testing", fslock.WithWatcher(watcher))
+	c.Assert(err, gc.IsNil)
+
+	err = lock1.Lock("")
+	c.Assert(err, gc.IsNil)
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock("")
+	}()
+	time.Sleep(shortWait)
+
+	err = lock1.Unlock()
+	c.Assert(err, gc.IsNil)
+	select {
+	case err := <-acquired:
+		c.Assert(err, gc.IsNil)
+	case <-time.After(longWait):
+		c.Fatalf("Expected lock acquisition")
+	}
+}
@@ -4,22 +4,42 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"math/big"
+	"net"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 // UUID represent a universal identifier with 16 octets.
 type UUID [16]byte
 
+// Nil is the nil UUID, with every bit zero, which can be used to mean
+// that a UUID hasn't been set. It is the zero value of UUID, and has
+// version 0.
+var Nil UUID
+
+const nilUUIDString = "00000000-0000-0000-0000-000000000000"
+
 // regex for validating that the UUID matches RFC 4122.
-// This package specifically generates and accepts version 4 UUIDs
+// IsValidUUIDString and UUIDFromString specifically accept version 4 UUIDs
 // where the string representation has the digit 4 at the beginning of
 // the third grouping, and one of the hex digits 8 through b at the
-// beginning of the fourth grouping.
+// beginning of the fourth grouping. ParseUUID accepts UUIDs of any
+// version and variant.
 // http://www.ietf.org/rfc/rfc4122.txt
 var (
 	block1  = "[0-9a-f]{8}"
@@ -31,14 +51,41 @@ var (
 	block5  = "[0-9a-f]{12}"
 
 	validUUID = regexp.MustCompile("^" + block1 + "-" + block2 + "-" + version + block3 + "-" + variant + block4 + "-" + block5 + "$")
+	anyUUID   = regexp.MustCompile("^" + block1 + "-" + block2 + "-" + block2 + "-" + block2 + "-" + block5 + "$")
+
+	// compactUUID matches UUIDs written without hyphens.
+	compactUUID = regexp.MustCompile("^[0-9a-f]{32}$")
 )
 
+// UUIDFromString returns the version 4 UUID with the given string
+// representation, or the nil UUID; UUIDs of other versions or variants
+// are rejected, as they are by IsValidUUIDString. The string may be in
+// any of the forms accepted by ParseUUID.
 func UUIDFromString(s string) (UUID, error) {
-	if !IsValidUUIDString(s) {
+	uuid, err := ParseUUID(s)
+	if err != nil {
+		return UUID{}, err
+	}
+	if !uuid.IsNil() && (uuid.Version() != 4 || uuid.Variant() != VariantRFC4122) {
 		return UUID{}, fmt.Errorf("invalid UUID: %q", s)
 	}
-	s = strings.Replace(s, "-", "", 4)
-	raw, err := hex.DecodeString(s)
+	return uuid, nil
+}
+
+// ParseUUID returns the UUID with the given string representation,
+// whatever its version; use Version to check that it is the kind of
+// UUID expected. As well as the form returned by String, the string may
+// be surrounded by braces, as Microsoft GUIDs often are, or be a URN as
+// defined by RfC 4122, starting "urn:uuid:". The hyphens may also be
+// left out altogether, leaving 32 hex digits, which may be upper or
+// lower case.
+func ParseUUID(s string) (UUID, error) {
+	trimmed := strings.ToLower(trimUUIDString(s))
+	if !anyUUID.MatchString(trimmed) && !compactUUID.MatchString(trimmed) {
+		return UUID{}, fmt.Errorf("invalid UUID: %q", s)
+	}
+	trimmed = strings.Replace(trimmed, "-", "", 4)
+	raw, err := hex.DecodeString(trimmed)
 	if err != nil {
 		return UUID{}, err
 	}
@@ -47,25 +94,458 @@ func UUIDFromString(s string) (UUID, error) {
 	return uuid, nil
 }
 
-// IsValidUUIDString returns true, if the given string matches a valid UUID (version 4, variant 2).
+// urnPrefix starts the URN form of a UUID.
+const urnPrefix = "urn:uuid:"
+
+// trimUUIDString removes the braces or URN prefix that ParseUUID allows
+// around a UUID.
+func trimUUIDString(s string) string {
+	switch {
+	case len(s) >= len(urnPrefix) && strings.EqualFold(s[:len(urnPrefix)], urnPrefix):
+		return s[len(urnPrefix):]
+	case strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}"):
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// UUIDFromBytes returns the UUID made of the given 16 bytes, such as
+// those returned by Raw or MarshalBinary, whatever its version. Any
+// other number of bytes is an error.
+func UUIDFromBytes(b []byte) (UUID, error) {
+	var uuid UUID
+	if err := uuid.UnmarshalBinary(b); err != nil {
+		return UUID{}, err
+	}
+	return uuid, nil
+}
+
+// MustUUIDFromString returns the UUID with the given string
+// representation, as UUIDFromString does, panicking if it isn't valid.
+// It is intended for UUIDs known in advance, in package variables and
+// tests.
+func MustUUIDFromString(s string) UUID {
+	uuid, err := UUIDFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return uuid
+}
+
+// IsValidUUIDString returns true, if the given string matches a valid UUID (version 4, variant 2),
+// or is the nil UUID. Hex digits may be upper or lower case.
 func IsValidUUIDString(s string) bool {
-	return validUUID.MatchString(s)
+	return ValidateUUIDString(s) == nil
+}
+
+// ValidateUUIDString returns an error describing why the given string
+// isn't a valid UUID, as IsValidUUIDString requires, or nil if it is.
+func ValidateUUIDString(s string) error {
+	if len(s) != len(nilUUIDString) {
+		return fmt.Errorf("invalid UUID %q: length %d, not %d", s, len(s), len(nilUUIDString))
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return fmt.Errorf("invalid UUID %q: %q at position %d, not '-'", s, s[i], i)
+			}
+		default:
+			if !isHexDigit(s[i]) {
+				return fmt.Errorf("invalid UUID %q: %q at position %d is not a hex digit", s, s[i], i)
+			}
+		}
+	}
+	if s == nilUUIDString {
+		return nil
+	}
+	if s[14] != '4' {
+		return fmt.Errorf("invalid UUID %q: version %c, not 4", s, s[14])
+	}
+	if !strings.ContainsRune("89abAB", rune(s[19])) {
+		return fmt.Errorf("invalid UUID %q: variant is not RFC 4122", s)
+	}
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// IsStrictUUIDString returns true, if the given string matches a valid UUID (version 4, variant 2),
+// or is the nil UUID, written in lower case as String writes it.
+func IsStrictUUIDString(s string) bool {
+	return validUUID.MatchString(s) || s == nilUUIDString
 }
 
 // NewUUID generates a new version 4 UUID relying only on random numbers.
+// The random numbers come from the system's secure random number
+// generator, through a Generator shared by the whole process.
 func NewUUID() (UUID, error) {
+	return defaultGenerator.New()
+}
+
+// generatorBufferSize is how many random bytes a Generator reads at a
+// time: enough for 256 UUIDs.
+const generatorBufferSize = 256 * 16
+
+// defaultGenerator is the Generator used by NewUUID.
+var defaultGenerator = NewGenerator(rand.Reader)
+
+// Generator generates version 4 UUIDs from random numbers that it reads
+// in large chunks, so that it doesn't need to read from its source for
+// every UUID. It is safe to use from multiple goroutines.
+type Generator struct {
+	r io.Reader
+
+	// mu guards buf, which holds the random bytes read but not yet
+	// used, and chunk, which holds the last chunk of bytes read.
+	mu    sync.Mutex
+	buf   []byte
+	chunk []byte
+}
+
+// NewGenerator returns a Generator that reads its random numbers from
+// r. The UUIDs it generates are only as unpredictable, and as unlikely
+// to clash, as the bytes read.
+func NewGenerator(r io.Reader) *Generator {
+	return &Generator{r: r}
+}
+
+// New generates a new version 4 UUID.
+func (g *Generator) New() (UUID, error) {
+	var uuid UUID
+	g.mu.Lock()
+	if len(g.buf) < len(uuid) {
+		if g.chunk == nil {
+			g.chunk = make([]byte, generatorBufferSize)
+		}
+		if _, err := io.ReadFull(g.r, g.chunk); err != nil {
+			g.mu.Unlock()
+			return UUID{}, err
+		}
+		g.buf = g.chunk
+	}
+	copy(uuid[:], g.buf)
+	g.buf = g.buf[len(uuid):]
+	g.mu.Unlock()
+	uuid.setV4()
+	return uuid, nil
+}
+
+// NewUUIDFromReader generates a new version 4 UUID from 16 bytes read
+// from r, instead of the system's secure random number generator. The
+// UUIDs are only as unpredictable, and as unlikely to clash, as the
+// bytes read; this is mostly useful for tests that need the same UUIDs
+// each time.
+func NewUUIDFromReader(r io.Reader) (UUID, error) {
 	uuid := UUID{}
-	if _, err := io.ReadFull(rand.Reader, []byte(uuid[0:16])); err != nil {
+	if _, err := io.ReadFull(r, []byte(uuid[0:16])); err != nil {
 		return UUID{}, err
 	}
+	uuid.setV4()
+	return uuid, nil
+}
+
+// NewUUIDs generates n new version 4 UUIDs, as NewUUID does, reading
+// the random numbers for all of them at once. This is much cheaper than
+// calling NewUUID n times when many UUIDs are needed.
+func NewUUIDs(n int) ([]UUID, error) {
+	// Larger n would overflow the size of the random numbers needed.
+	const maxInt = int(^uint(0) >> 1)
+	if n < 0 || n > maxInt/len(UUID{}) {
+		return nil, fmt.Errorf("cannot generate %d UUIDs", n)
+	}
+	random := make([]byte, n*len(UUID{}))
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	uuids := make([]UUID, n)
+	for i := range uuids {
+		copy(uuids[i][:], random[i*len(UUID{}):])
+		uuids[i].setV4()
+	}
+	return uuids, nil
+}
+
+// setV4 sets the version and variant bits of a random UUID.
+func (uuid *UUID) setV4() {
 	// Set version (4) and variant (2) according to RfC 4122.
 	var version byte = 4 << 4
 	var variant byte = 8 << 4
 	uuid[6] = version | (uuid[6] & 15)
 	uuid[8] = variant | (uuid[8] & 15)
+}
+
+// MustNewUUID returns a new version 4 UUID, as NewUUID does, panicking
+// if it can't, which only happens if the system's secure random number
+// generator fails. It is intended for initialising package variables
+// and tests.
+func MustNewUUID() UUID {
+	uuid, err := NewUUID()
+	if err != nil {
+		panic(err)
+	}
+	return uuid
+}
+
+// gregorianToUnix is the number of 100ns intervals between the start of
+// the Gregorian calendar, 1582-10-15, from which version 1 UUIDs count
+// time, and the Unix epoch.
+const gregorianToUnix = 122192928000000000
+
+// v1State holds what is needed to keep version 1 UUIDs generated by this
+// process unique.
+var v1State struct {
+	mu       sync.Mutex
+	lastTime uint64
+	clockSeq uint16
+	node     []byte
+}
+
+// NewUUIDV1 generates a new version 1 UUID from the current time, a
+// clock sequence and the node identifier of this machine. The node
+// identifier is the MAC address of one of the machine's network
+// interfaces, or a random number if none has one, so be aware that
+// version 1 UUIDs reveal which machine generated them, and when.
+func NewUUIDV1() (UUID, error) {
+	v1State.mu.Lock()
+	defer v1State.mu.Unlock()
+	if v1State.node == nil {
+		var seq [2]byte
+		if _, err := io.ReadFull(rand.Reader, seq[:]); err != nil {
+			return UUID{}, err
+		}
+		node, err := nodeID()
+		if err != nil {
+			return UUID{}, err
+		}
+		v1State.clockSeq = binary.BigEndian.Uint16(seq[:])
+		v1State.node = node
+	}
+	now := uint64(time.Now().UnixNano()/100) + gregorianToUnix
+	if now <= v1State.lastTime {
+		// The clock hasn't moved on since the last UUID, or has gone
+		// backwards; change the clock sequence so that this UUID is
+		// still unique.
+		v1State.clockSeq++
+	}
+	v1State.lastTime = now
+
+	var uuid UUID
+	binary.BigEndian.PutUint32(uuid[0:4], uint32(now))
+	binary.BigEndian.PutUint16(uuid[4:6], uint16(now>>32))
+	binary.BigEndian.PutUint16(uuid[6:8], uint16(now>>48))
+	binary.BigEndian.PutUint16(uuid[8:10], v1State.clockSeq)
+	copy(uuid[10:16], v1State.node)
+	// Set version (1) and variant (2) according to RfC 4122.
+	var version byte = 1 << 4
+	var variant byte = 8 << 4
+	uuid[6] = version | (uuid[6] & 15)
+	uuid[8] = variant | (uuid[8] & 63)
+	return uuid, nil
+}
+
+// nodeID returns the MAC address of the first network interface that
+// has one, or a random node identifier if there is none.
+func nodeID() ([]byte, error) {
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		addr := iface.HardwareAddr
+		if len(addr) >= 6 && !allZero(addr[:6]) {
+			return addr[:6], nil
+		}
+	}
+	node := make([]byte, 6)
+	if _, err := io.ReadFull(rand.Reader, node); err != nil {
+		return nil, err
+	}
+	// Setting the multicast bit keeps a random node identifier from
+	// clashing with a real MAC address, as RfC 4122 suggests.
+	node[0] |= 1
+	return node, nil
+}
+
+func allZero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// v7State holds what is needed to keep version 7 UUIDs generated by this
+// process in order.
+var v7State struct {
+	mu         sync.Mutex
+	lastMillis uint64
+	seq        uint16
+}
+
+// NewUUIDV7 generates a new version 7 UUID, as defined by RfC 9562,
+// starting with the current Unix time in milliseconds and otherwise
+// random. Version 7 UUIDs sort in the order in which they were
+// generated, which makes them good database keys. Within a process,
+// this holds even for UUIDs generated in the same millisecond: the 12
+// bits after the version count them, starting from a random value in
+// the lower half of the range each millisecond, and the timestamp is
+// moved on a millisecond whenever the count runs out.
+func NewUUIDV7() (UUID, error) {
+	var uuid UUID
+	if _, err := io.ReadFull(rand.Reader, uuid[6:16]); err != nil {
+		return UUID{}, err
+	}
+	v7State.mu.Lock()
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if now > v7State.lastMillis {
+		v7State.lastMillis = now
+		v7State.seq = binary.BigEndian.Uint16(uuid[6:8]) & 0x7ff
+	} else {
+		// Either this millisecond has been used already, or the clock
+		// has gone backwards; carry on counting from the last UUID.
+		v7State.seq++
+		if v7State.seq > 0xfff {
+			v7State.lastMillis++
+			v7State.seq = 0
+		}
+	}
+	millis, seq := v7State.lastMillis, v7State.seq
+	v7State.mu.Unlock()
+
+	uuid[0] = byte(millis >> 40)
+	uuid[1] = byte(millis >> 32)
+	binary.BigEndian.PutUint32(uuid[2:6], uint32(millis))
+	binary.BigEndian.PutUint16(uuid[6:8], seq)
+	// Set version (7) and variant (2) according to RfC 9562.
+	var version byte = 7 << 4
+	var variant byte = 8 << 4
+	uuid[6] = version | (uuid[6] & 15)
+	uuid[8] = variant | (uuid[8] & 63)
 	return uuid, nil
 }
 
+// Namespaces defined by RfC 4122 for name based UUIDs, in which the
+// names are fully qualified domain names, URLs, ISO object identifiers
+// and X.500 distinguished names respectively.
+var (
+	NamespaceDNS  = UUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	NamespaceURL  = UUID{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	NamespaceOID  = UUID{0x6b, 0xa7, 0xb8, 0x12, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	NamespaceX500 = UUID{0x6b, 0xa7, 0xb8, 0x14, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+)
+
+// NewUUIDV5 generates the version 5 UUID for the given name in the
+// given namespace, from their SHA-1 hash. The same namespace and name
+// always give the same UUID.
+func NewUUIDV5(namespace UUID, name []byte) UUID {
+	return newNameUUID(sha1.New(), 5, namespace, name)
+}
+
+// NewUUIDV3 generates the version 3 UUID for the given name in the
+// given namespace, from their MD5 hash. The same namespace and name
+// always give the same UUID. Version 3 UUIDs are only needed to work
+// with systems that already use them; NewUUIDV5 should be preferred for
+// anything new.
+func NewUUIDV3(namespace UUID, name []byte) UUID {
+	return newNameUUID(md5.New(), 3, namespace, name)
+}
+
+// newNameUUID returns the name based UUID with the given version, made
+// from the hash of the namespace followed by the name.
+func newNameUUID(h hash.Hash, version byte, namespace UUID, name []byte) UUID {
+	h.Write(namespace[:])
+	h.Write(name)
+	var uuid UUID
+	copy(uuid[:], h.Sum(nil))
+	// Set version and variant (2) according to RfC 4122.
+	var variant byte = 8 << 4
+	uuid[6] = version<<4 | (uuid[6] & 15)
+	uuid[8] = variant | (uuid[8] & 63)
+	return uuid
+}
+
+// Version returns the version of the UUID, held in the top four bits
+// of its seventh byte: 4 for those generated by NewUUID, for instance.
+// Nil has version 0.
+func (uuid UUID) Version() int {
+	return int(uuid[6] >> 4)
+}
+
+// Variant describes the layout of a UUID, as given by its variant bits.
+type Variant int
+
+// The variants of UUID defined by RfC 4122.
+const (
+	// VariantNCS is the layout kept for backward compatibility with
+	// Apollo Network Computing System UUIDs.
+	VariantNCS Variant = iota
+
+	// VariantRFC4122 is the layout defined by RfC 4122, used by every
+	// UUID generated by this package.
+	VariantRFC4122
+
+	// VariantMicrosoft is the layout kept for backward compatibility
+	// with Microsoft GUIDs.
+	VariantMicrosoft
+
+	// VariantFuture is reserved for future definition.
+	VariantFuture
+)
+
+var variantNames = [...]string{
+	VariantNCS:       "NCS",
+	VariantRFC4122:   "RFC 4122",
+	VariantMicrosoft: "Microsoft",
+	VariantFuture:    "future",
+}
+
+// String returns the name of the variant.
+func (v Variant) String() string {
+	if v < 0 || int(v) >= len(variantNames) {
+		return fmt.Sprintf("Variant(%d)", int(v))
+	}
+	return variantNames[v]
+}
+
+// Variant returns the variant of the UUID, held in the top bits of its
+// ninth byte. Nil has the NCS variant.
+func (uuid UUID) Variant() Variant {
+	switch {
+	case uuid[8]&0x80 == 0:
+		return VariantNCS
+	case uuid[8]&0xc0 == 0x80:
+		return VariantRFC4122
+	case uuid[8]&0xe0 == 0xc0:
+		return VariantMicrosoft
+	}
+	return VariantFuture
+}
+
+// IsNil reports whether the UUID is the nil UUID.
+func (uuid UUID) IsNil() bool {
+	return uuid == Nil
+}
+
+// Equal reports whether the UUID is the same as other.
+func (uuid UUID) Equal(other UUID) bool {
+	return uuid == other
+}
+
+// Compare compares the bytes of the UUID with those of other, in order,
+// returning -1, 0 or +1 as the UUID is less than, equal to or greater
+// than other. Version 7 UUIDs compare in the order they were generated.
+func (uuid UUID) Compare(other UUID) int {
+	return bytes.Compare(uuid[:], other[:])
+}
+
+// UUIDs implements sort.Interface, ordering UUIDs as Compare does.
+type UUIDs []UUID
+
+func (u UUIDs) Len() int           { return len(u) }
+func (u UUIDs) Less(i, j int) bool { return u[i].Compare(u[j]) < 0 }
+func (u UUIDs) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
 // Copy returns a copy of the UUID.
 func (uuid UUID) Copy() UUID {
 	uuidCopy := uuid
@@ -78,7 +558,311 @@ func (uuid UUID) Raw() [16]byte {
 }
 
 // String returns a hexadecimal string representation with
-// standardized separators.
+// standardized separators. This lower case, hyphenated form is the
+// canonical one.
 func (uuid UUID) String() string {
-	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+	var buf [36]byte
+	return string(uuid.AppendString(buf[:0]))
+}
+
+// AppendString appends the string representation of the UUID, as
+// returned by String, to b and returns the extended buffer. Reusing the
+// buffer avoids allocating a new string for every UUID formatted.
+func (uuid UUID) AppendString(b []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	for i, x := range uuid {
+		switch i {
+		case 4, 6, 8, 10:
+			b = append(b, '-')
+		}
+		b = append(b, hexDigits[x>>4], hexDigits[x&15])
+	}
+	return b
+}
+
+// Format implements fmt.Formatter. The verbs %s and %v print the UUID
+// as String does, %q prints that quoted, and %x and %X print it as 32
+// hex digits in lower and upper case. With %#v, the UUID is printed as
+// Go syntax. Widths and flags are applied to the resulting text.
+func (uuid UUID) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if f.Flag('#') {
+			fmt.Fprintf(f, "utils.UUID{")
+			for i, x := range uuid {
+				if i > 0 {
+					fmt.Fprintf(f, ", ")
+				}
+				fmt.Fprintf(f, "%#02x", x)
+			}
+			fmt.Fprintf(f, "}")
+			return
+		}
+		fmt.Fprintf(f, directive(f, 's'), uuid.String())
+	case 's', 'q':
+		fmt.Fprintf(f, directive(f, verb), uuid.String())
+	case 'x':
+		fmt.Fprintf(f, directive(f, 's'), uuid.StringCompact())
+	case 'X':
+		fmt.Fprintf(f, directive(f, 's'), strings.ToUpper(uuid.StringCompact()))
+	default:
+		fmt.Fprintf(f, "%%!%c(utils.UUID=%s)", verb, uuid.String())
+	}
+}
+
+// directive returns the formatting directive, with the given verb,
+// that has the same flags, width and precision as f.
+func directive(f fmt.State, verb rune) string {
+	d := "%"
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			d += string(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		d += fmt.Sprint(width)
+	}
+	if prec, ok := f.Precision(); ok {
+		d += "." + fmt.Sprint(prec)
+	}
+	return d + string(verb)
+}
+
+// StringCompact returns the UUID as 32 lower case hex digits, without
+// the separators.
+func (uuid UUID) StringCompact() string {
+	return hex.EncodeToString(uuid[:])
+}
+
+// StringUpper returns the UUID in the same form as String, but with
+// upper case hex digits, as Microsoft GUIDs are often written.
+func (uuid UUID) StringUpper() string {
+	return strings.ToUpper(uuid.String())
+}
+
+// base58Alphabet holds the digits used by Short, which leave out those
+// easily mistaken for one another: 0, O, I and l.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// maxShortLen is the length of the longest string returned by Short.
+const maxShortLen = 22
+
+// Short returns a short form of the UUID, for showing to people, in at
+// most 22 base58 digits. It can be turned back into the UUID with
+// UUIDFromShort. As in Bitcoin addresses, each leading zero byte is
+// written as a "1", so leading zeros aren't lost.
+func (uuid UUID) Short() string {
+	var digits []byte
+	n := new(big.Int).SetBytes(uuid[:])
+	base := big.NewInt(int64(len(base58Alphabet)))
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		digits = append(digits, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range uuid {
+		if b != 0 {
+			break
+		}
+		digits = append(digits, base58Alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// UUIDFromShort returns the UUID with the given short form, as returned
+// by Short.
+func UUIDFromShort(s string) (UUID, error) {
+	if len(s) > maxShortLen {
+		return UUID{}, fmt.Errorf("invalid short UUID: %q", s)
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	n := new(big.Int)
+	base := big.NewInt(int64(len(base58Alphabet)))
+	for i := zeros; i < len(s); i++ {
+		digit := strings.IndexByte(base58Alphabet, s[i])
+		if digit < 0 {
+			return UUID{}, fmt.Errorf("invalid short UUID: %q", s)
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	b := n.Bytes()
+	var uuid UUID
+	if zeros+len(b) != len(uuid) {
+		return UUID{}, fmt.Errorf("invalid short UUID: %q", s)
+	}
+	copy(uuid[zeros:], b)
+	return uuid, nil
+}
+
+// Base64 returns the UUID encoded in URL safe base64, without padding,
+// in 22 characters. It can be turned back into the UUID with
+// UUIDFromBase64.
+func (uuid UUID) Base64() string {
+	return base64.RawURLEncoding.EncodeToString(uuid[:])
+}
+
+// UUIDFromBase64 returns the UUID with the given base64 form, as
+// returned by Base64.
+func UUIDFromBase64(s string) (UUID, error) {
+	var uuid UUID
+	if base64.RawURLEncoding.EncodedLen(len(uuid)) != len(s) {
+		return UUID{}, fmt.Errorf("invalid base64 UUID: %q", s)
+	}
+	// Strict decoding rejects unused bits that aren't zero, so that
+	// each UUID has only one base64 form.
+	if _, err := base64.RawURLEncoding.Strict().Decode(uuid[:], []byte(s)); err != nil {
+		return UUID{}, fmt.Errorf("invalid base64 UUID: %q", s)
+	}
+	return uuid, nil
+}
+
+// URN returns the URN form of the UUID defined by RfC 4122, which is
+// accepted by ParseUUID.
+func (uuid UUID) URN() string {
+	return urnPrefix + uuid.String()
+}
+
+// MarshalJSON implements json.Marshaler, encoding the UUID as its
+// string representation.
+func (uuid UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(uuid.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding a UUID of any
+// version from its string representation, as ParseUUID does. A JSON
+// null decodes as Nil.
+func (uuid *UUID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*uuid = Nil
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid UUID: %s", data)
+	}
+	parsed, err := ParseUUID(s)
+	if err != nil {
+		return err
+	}
+	*uuid = parsed
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the UUID as
+// its string representation. This also lets UUIDs be used as JSON
+// object keys.
+func (uuid UUID) MarshalText() ([]byte, error) {
+	return []byte(uuid.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding a UUID
+// from its string representation as ParseUUID does.
+func (uuid *UUID) UnmarshalText(text []byte) error {
+	parsed, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*uuid = parsed
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the UUID
+// as its 16 bytes.
+func (uuid UUID) MarshalBinary() ([]byte, error) {
+	return uuid[:], nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, decoding a
+// UUID from exactly 16 bytes.
+func (uuid *UUID) UnmarshalBinary(data []byte) error {
+	if len(data) != len(uuid) {
+		return fmt.Errorf("invalid UUID: %d bytes, not %d", len(data), len(uuid))
+	}
+	copy(uuid[:], data)
+	return nil
+}
+
+// Value implements driver.Valuer, storing the UUID in a database as its
+// string representation. Columns holding the 16 bytes of a UUID can be
+// written with the result of MarshalBinary instead.
+func (uuid UUID) Value() (driver.Value, error) {
+	return uuid.String(), nil
+}
+
+// Scan implements sql.Scanner, reading a UUID from a database column
+// holding either its string representation or its 16 bytes. A NULL
+// column reads as Nil.
+func (uuid *UUID) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*uuid = Nil
+		return nil
+	case string:
+		return uuid.UnmarshalText([]byte(src))
+	case []byte:
+		if len(src) == len(uuid) {
+			return uuid.UnmarshalBinary(src)
+		}
+		return uuid.UnmarshalText(src)
+	}
+	return fmt.Errorf("cannot scan %T into UUID", src)
+}
+
+// NullUUID holds a UUID that may be null, as sql.NullString does for
+// strings. It implements sql.Scanner and driver.Valuer for nullable
+// database columns, and is encoded in JSON as null when not Valid.
+type NullUUID struct {
+	UUID UUID
+
+	// Valid is set when UUID holds a value.
+	Valid bool
+}
+
+// Scan implements sql.Scanner.
+func (n *NullUUID) Scan(src interface{}) error {
+	if src == nil {
+		n.UUID, n.Valid = Nil, false
+		return nil
+	}
+	if err := n.UUID.Scan(src); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (n NullUUID) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.UUID.Value()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (n NullUUID) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return n.UUID.MarshalJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *NullUUID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		n.UUID, n.Valid = Nil, false
+		return nil
+	}
+	if err := n.UUID.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
 }
@@ -4,6 +4,18 @@
 package utils_test
 
 import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"sort"
+	stdtesting "testing"
+	"time"
+
 	"github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
@@ -35,11 +47,169 @@ func (*uuidSuite) TestUUID(c *gc.C) {
 	c.Assert(uuid, gc.Not(gc.DeepEquals), nextUUID)
 }
 
+func (*uuidSuite) TestNewUUIDFromReader(c *gc.C) {
+	r := bytes.NewReader(bytes.Repeat([]byte{0xff}, 32))
+	uuid, err := utils.NewUUIDFromReader(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.String(), gc.Equals, "ffffffff-ffff-4fff-8fff-ffffffffffff")
+	c.Assert(uuid.String(), jc.Satisfies, utils.IsValidUUIDString)
+
+	// The same bytes give the same UUID.
+	next, err := utils.NewUUIDFromReader(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(next, gc.Equals, uuid)
+
+	_, err = utils.NewUUIDFromReader(r)
+	c.Assert(err, gc.Equals, io.EOF)
+	_, err = utils.NewUUIDFromReader(bytes.NewReader(make([]byte, 15)))
+	c.Assert(err, gc.Equals, io.ErrUnexpectedEOF)
+}
+
+func (*uuidSuite) TestNewUUIDs(c *gc.C) {
+	uuids, err := utils.NewUUIDs(1000)
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuids, gc.HasLen, 1000)
+	seen := make(map[utils.UUID]bool)
+	for _, uuid := range uuids {
+		c.Assert(uuid.String(), jc.Satisfies, utils.IsValidUUIDString)
+		c.Assert(seen[uuid], gc.Equals, false)
+		seen[uuid] = true
+	}
+
+	uuids, err = utils.NewUUIDs(0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuids, gc.HasLen, 0)
+	_, err = utils.NewUUIDs(-1)
+	c.Assert(err, gc.ErrorMatches, `cannot generate -1 UUIDs`)
+	const maxInt = int(^uint(0) >> 1)
+	_, err = utils.NewUUIDs(maxInt/16 + 1)
+	c.Assert(err, gc.ErrorMatches, `cannot generate [0-9]+ UUIDs`)
+}
+
+// readCounter counts the reads made from a reader.
+type readCounter struct {
+	r     io.Reader
+	reads int
+}
+
+func (r *readCounter) Read(b []byte) (int, error) {
+	r.reads++
+	return r.r.Read(b)
+}
+
+func (*uuidSuite) TestGenerator(c *gc.C) {
+	random := make([]byte, 2*256*16)
+	for i := range random {
+		random[i] = byte(i / 16)
+	}
+	r := &readCounter{r: bytes.NewReader(random)}
+	g := utils.NewGenerator(r)
+	for i := 0; i < 2*256; i++ {
+		uuid, err := g.New()
+		c.Assert(err, gc.IsNil)
+		c.Assert(uuid.Version(), gc.Equals, 4)
+		c.Assert(uuid.Variant(), gc.Equals, utils.VariantRFC4122)
+		c.Assert(uuid[0], gc.Equals, byte(i))
+	}
+	// The random numbers are read 256 UUIDs at a time.
+	c.Assert(r.reads, gc.Equals, 2)
+
+	_, err := g.New()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (*uuidSuite) TestGeneratorConcurrent(c *gc.C) {
+	g := utils.NewGenerator(rand.New(rand.NewSource(0)))
+	const n = 10
+	results := make(chan []utils.UUID, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			var uuids []utils.UUID
+			for j := 0; j < 100; j++ {
+				uuid, err := g.New()
+				c.Check(err, gc.IsNil)
+				uuids = append(uuids, uuid)
+			}
+			results <- uuids
+		}()
+	}
+	seen := make(map[utils.UUID]bool)
+	for i := 0; i < n; i++ {
+		for _, uuid := range <-results {
+			c.Assert(seen[uuid], gc.Equals, false)
+			seen[uuid] = true
+		}
+	}
+}
+
+func (*uuidSuite) TestMustNewUUID(c *gc.C) {
+	uuid := utils.MustNewUUID()
+	c.Assert(uuid.String(), jc.Satisfies, utils.IsValidUUIDString)
+	c.Assert(uuid.Version(), gc.Equals, 4)
+	c.Assert(utils.MustNewUUID(), gc.Not(gc.Equals), uuid)
+}
+
 func (*uuidSuite) TestIsValidUUIDFailsWhenNotValid(c *gc.C) {
 	c.Check(utils.IsValidUUIDString("blah"), gc.Equals, false)
 	c.Check(utils.IsValidUUIDString("blah-9f484882-2f18-4fd2-967d-db9663db7bea"), gc.Equals, false)
 	c.Check(utils.IsValidUUIDString("9f484882-2f18-4fd2-967d-db9663db7bea-blah"), gc.Equals, false)
 	c.Check(utils.IsValidUUIDString("9f484882-2f18-4fd2-967d-db9663db7bea"), gc.Equals, true)
+	c.Check(utils.IsValidUUIDString("00000000-0000-0000-0000-000000000000"), gc.Equals, true)
+	c.Check(utils.IsValidUUIDString("9F484882-2F18-4FD2-967D-DB9663DB7BEA"), gc.Equals, true)
+	c.Check(utils.IsValidUUIDString("9F484882-2F18-4FD2-C67D-DB9663DB7BEA"), gc.Equals, false)
+}
+
+var validateUUIDStringTests = []struct {
+	uuid string
+	err  string
+}{{
+	uuid: "9f484882-2f18-4fd2-967d-db9663db7bea",
+}, {
+	uuid: "9F484882-2F18-4FD2-B67D-DB9663DB7BEA",
+}, {
+	uuid: "00000000-0000-0000-0000-000000000000",
+}, {
+	uuid: "blah",
+	err:  `invalid UUID "blah": length 4, not 36`,
+}, {
+	uuid: "9f4848822f184fd2967ddb9663db7bea",
+	err:  `invalid UUID "9f4848822f184fd2967ddb9663db7bea": length 32, not 36`,
+}, {
+	uuid: "9f484882-2f18-4fd2-967d-db9663db7beg",
+	err:  `invalid UUID "9f484882-2f18-4fd2-967d-db9663db7beg": 'g' at position 35 is not a hex digit`,
+}, {
+	uuid: "9f484882-2f18-4fd2-967d-db9663db7be-",
+	err:  `invalid UUID "9f484882-2f18-4fd2-967d-db9663db7be-": '-' at position 35 is not a hex digit`,
+}, {
+	uuid: "9f484882-2f18_4fd2-967d-db9663db7bea",
+	err:  `invalid UUID "9f484882-2f18_4fd2-967d-db9663db7bea": '_' at position 13, not '-'`,
+}, {
+	uuid: "9f484882-2f18-5fd2-967d-db9663db7bea",
+	err:  `invalid UUID "9f484882-2f18-5fd2-967d-db9663db7bea": version 5, not 4`,
+}, {
+	uuid: "9f484882-2f18-4fd2-c67d-db9663db7bea",
+	err:  `invalid UUID "9f484882-2f18-4fd2-c67d-db9663db7bea": variant is not RFC 4122`,
+}}
+
+func (*uuidSuite) TestValidateUUIDString(c *gc.C) {
+	for i, test := range validateUUIDStringTests {
+		c.Logf("test %d: %s", i, test.uuid)
+		err := utils.ValidateUUIDString(test.uuid)
+		if test.err == "" {
+			c.Check(err, gc.IsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, regexp.QuoteMeta(test.err))
+		}
+		c.Check(utils.IsValidUUIDString(test.uuid), gc.Equals, test.err == "")
+	}
+}
+
+func (*uuidSuite) TestIsStrictUUIDString(c *gc.C) {
+	c.Check(utils.IsStrictUUIDString("9f484882-2f18-4fd2-967d-db9663db7bea"), gc.Equals, true)
+	c.Check(utils.IsStrictUUIDString("00000000-0000-0000-0000-000000000000"), gc.Equals, true)
+	c.Check(utils.IsStrictUUIDString("9F484882-2F18-4FD2-967D-DB9663DB7BEA"), gc.Equals, false)
+	c.Check(utils.IsStrictUUIDString("9f484882-2f18-4fd2-967d-db9663db7beA"), gc.Equals, false)
+	c.Check(utils.IsStrictUUIDString("9f484882-2f18-3fd2-967d-db9663db7bea"), gc.Equals, false)
 }
 
 func (*uuidSuite) TestUUIDFromString(c *gc.C) {
@@ -50,3 +220,674 @@ func (*uuidSuite) TestUUIDFromString(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 	c.Assert(uuid.String(), gc.Equals, validUUID)
 }
+
+// uuidString matches the string form of a UUID of any version or
+// variant.
+var uuidString = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+func (*uuidSuite) TestNewUUIDV1(c *gc.C) {
+	before := time.Now()
+	uuid, err := utils.NewUUIDV1()
+	c.Assert(err, gc.IsNil)
+	after := time.Now()
+	c.Assert(uuid.Version(), gc.Equals, 1)
+	c.Assert(uuid[8]&0xc0, gc.Equals, byte(0x80))
+	c.Assert(uuid.String(), gc.Matches, uuidString.String())
+
+	// The timestamp counts 100ns intervals since the start of the
+	// Gregorian calendar.
+	ts := uint64(uuid[6]&0x0f)<<56 | uint64(uuid[7])<<48 |
+		uint64(uuid[4])<<40 | uint64(uuid[5])<<32 |
+		uint64(uuid[0])<<24 | uint64(uuid[1])<<16 | uint64(uuid[2])<<8 | uint64(uuid[3])
+	const gregorianToUnix = 122192928000000000
+	generated := time.Unix(0, int64(ts-gregorianToUnix)*100)
+	c.Assert(generated.Before(before.Add(-time.Microsecond)), gc.Equals, false)
+	c.Assert(generated.After(after), gc.Equals, false)
+}
+
+func (*uuidSuite) TestNewUUIDV1Unique(c *gc.C) {
+	seen := make(map[utils.UUID]bool)
+	var node []byte
+	for i := 0; i < 1000; i++ {
+		uuid, err := utils.NewUUIDV1()
+		c.Assert(err, gc.IsNil)
+		c.Assert(seen[uuid], gc.Equals, false)
+		seen[uuid] = true
+		if node == nil {
+			node = append(node, uuid[10:]...)
+		}
+		c.Assert(uuid[10:], gc.DeepEquals, node)
+	}
+}
+
+func (*uuidSuite) TestNewUUIDV7(c *gc.C) {
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	uuid, err := utils.NewUUIDV7()
+	c.Assert(err, gc.IsNil)
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+	c.Assert(uuid.Version(), gc.Equals, 7)
+	c.Assert(uuid[8]&0xc0, gc.Equals, byte(0x80))
+	c.Assert(uuid.String(), gc.Matches, uuidString.String())
+
+	var millis int64
+	for _, b := range uuid[0:6] {
+		millis = millis<<8 | int64(b)
+	}
+	c.Assert(millis >= before, gc.Equals, true)
+	c.Assert(millis <= after+1, gc.Equals, true)
+}
+
+func (*uuidSuite) TestNewUUIDV7Ordered(c *gc.C) {
+	// Many of these are generated in the same millisecond.
+	last, err := utils.NewUUIDV7()
+	c.Assert(err, gc.IsNil)
+	for i := 0; i < 10000; i++ {
+		uuid, err := utils.NewUUIDV7()
+		c.Assert(err, gc.IsNil)
+		if uuid.String() <= last.String() {
+			c.Fatalf("%s generated after %s", uuid, last)
+		}
+		last = uuid
+	}
+}
+
+func (*uuidSuite) TestNewUUIDV5(c *gc.C) {
+	uuid := utils.NewUUIDV5(utils.NamespaceDNS, []byte("www.example.com"))
+	c.Assert(uuid.String(), gc.Equals, "2ed6657d-e927-568b-95e1-2665a8aea6a2")
+	c.Assert(uuid.Version(), gc.Equals, 5)
+	c.Assert(utils.NewUUIDV5(utils.NamespaceDNS, []byte("www.example.com")), gc.Equals, uuid)
+
+	uuid = utils.NewUUIDV5(utils.NamespaceURL, []byte("http://www.example.com/"))
+	c.Assert(uuid.String(), gc.Equals, "fcde3c85-2270-590f-9e7c-ee003d65e0e2")
+}
+
+func (*uuidSuite) TestNewUUIDV3(c *gc.C) {
+	uuid := utils.NewUUIDV3(utils.NamespaceDNS, []byte("www.example.com"))
+	c.Assert(uuid.String(), gc.Equals, "5df41881-3aed-3515-88a7-2f4a814cf09e")
+	c.Assert(uuid.Version(), gc.Equals, 3)
+	c.Assert(utils.NewUUIDV3(utils.NamespaceDNS, []byte("www.example.com")), gc.Equals, uuid)
+	c.Assert(utils.NewUUIDV3(utils.NamespaceURL, []byte("www.example.com")), gc.Not(gc.Equals), uuid)
+}
+
+func (*uuidSuite) TestNamespaces(c *gc.C) {
+	c.Assert(utils.NamespaceDNS.String(), gc.Equals, "6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	c.Assert(utils.NamespaceURL.String(), gc.Equals, "6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	c.Assert(utils.NamespaceOID.String(), gc.Equals, "6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	c.Assert(utils.NamespaceX500.String(), gc.Equals, "6ba7b814-9dad-11d1-80b4-00c04fd430c8")
+}
+
+func (*uuidSuite) TestVersion(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.Version(), gc.Equals, 4)
+	c.Assert(utils.UUID{}.Version(), gc.Equals, 0)
+}
+
+var variantTests = []struct {
+	uuid    string
+	variant utils.Variant
+	name    string
+}{{
+	uuid:    "00000000-0000-0000-0000-000000000000",
+	variant: utils.VariantNCS,
+	name:    "NCS",
+}, {
+	uuid:    "9f484882-2f18-4fd2-767d-db9663db7bea",
+	variant: utils.VariantNCS,
+	name:    "NCS",
+}, {
+	uuid:    "9f484882-2f18-4fd2-967d-db9663db7bea",
+	variant: utils.VariantRFC4122,
+	name:    "RFC 4122",
+}, {
+	uuid:    "9f484882-2f18-4fd2-b67d-db9663db7bea",
+	variant: utils.VariantRFC4122,
+	name:    "RFC 4122",
+}, {
+	uuid:    "9f484882-2f18-4fd2-c67d-db9663db7bea",
+	variant: utils.VariantMicrosoft,
+	name:    "Microsoft",
+}, {
+	uuid:    "9f484882-2f18-4fd2-e67d-db9663db7bea",
+	variant: utils.VariantFuture,
+	name:    "future",
+}}
+
+func (*uuidSuite) TestVariant(c *gc.C) {
+	for i, test := range variantTests {
+		c.Logf("test %d: %s", i, test.uuid)
+		uuid, err := utils.ParseUUID(test.uuid)
+		c.Assert(err, gc.IsNil)
+		c.Assert(uuid.Variant(), gc.Equals, test.variant)
+		c.Assert(uuid.Variant().String(), gc.Equals, test.name)
+	}
+	uuid, err := utils.NewUUIDV7()
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.Variant(), gc.Equals, utils.VariantRFC4122)
+}
+
+func (*uuidSuite) TestUUIDFromStringForms(c *gc.C) {
+	const valid = "9f484882-2f18-4fd2-967d-db9663db7bea"
+	for _, s := range []string{
+		valid,
+		"{" + valid + "}",
+		"urn:uuid:" + valid,
+		"URN:UUID:" + valid,
+		"9f4848822f184fd2967ddb9663db7bea",
+		"{9f4848822f184fd2967ddb9663db7bea}",
+		"urn:uuid:9f4848822f184fd2967ddb9663db7bea",
+		"9F484882-2F18-4FD2-967D-DB9663DB7BEA",
+		"{9F484882-2f18-4FD2-967d-DB9663DB7BEA}",
+		"9F4848822F184FD2967DDB9663DB7BEA",
+	} {
+		c.Logf("uuid %q", s)
+		uuid, err := utils.UUIDFromString(s)
+		c.Assert(err, gc.IsNil)
+		c.Assert(uuid.String(), gc.Equals, valid)
+	}
+	for _, s := range []string{
+		"{" + valid,
+		valid + "}",
+		"{urn:uuid:" + valid + "}",
+		"urn:uuid:{" + valid + "}",
+		"uuid:" + valid,
+		"{}",
+		"urn:uuid:",
+		"9f4848822f184fd2967ddb9663db7be",
+		"9f4848822f184fd2967ddb9663db7beab",
+		"9f484882-2f184fd2967ddb9663db7bea",
+	} {
+		c.Logf("uuid %q", s)
+		_, err := utils.UUIDFromString(s)
+		c.Assert(err, gc.ErrorMatches, `invalid UUID: ".*"`)
+	}
+}
+
+func (*uuidSuite) TestAppendString(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	b := uuid.AppendString([]byte("id="))
+	c.Assert(string(b), gc.Equals, "id=9f484882-2f18-4fd2-967d-db9663db7bea")
+
+	buf := make([]byte, 0, 36)
+	allocs := stdtesting.AllocsPerRun(100, func() {
+		buf = uuid.AppendString(buf[:0])
+	})
+	c.Assert(allocs, gc.Equals, 0.0)
+	c.Assert(string(buf), gc.Equals, uuid.String())
+}
+
+var formatTests = []struct {
+	format string
+	expect string
+}{
+	{"%s", "9f484882-2f18-4fd2-967d-db9663db7bea"},
+	{"%v", "9f484882-2f18-4fd2-967d-db9663db7bea"},
+	{"%+v", "9f484882-2f18-4fd2-967d-db9663db7bea"},
+	{"%q", `"9f484882-2f18-4fd2-967d-db9663db7bea"`},
+	{"%x", "9f4848822f184fd2967ddb9663db7bea"},
+	{"%X", "9F4848822F184FD2967DDB9663DB7BEA"},
+	{"%40s|", "    9f484882-2f18-4fd2-967d-db9663db7bea|"},
+	{"%-40s|", "9f484882-2f18-4fd2-967d-db9663db7bea    |"},
+	{"%.8x", "9f484882"},
+	{"%#v", "utils.UUID{0x9f, 0x48, 0x48, 0x82, 0x2f, 0x18, 0x4f, 0xd2, 0x96, 0x7d, 0xdb, 0x96, 0x63, 0xdb, 0x7b, 0xea}"},
+	{"%d", "%!d(utils.UUID=9f484882-2f18-4fd2-967d-db9663db7bea)"},
+}
+
+func (*uuidSuite) TestFormat(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	for i, test := range formatTests {
+		c.Logf("test %d: %s", i, test.format)
+		c.Check(fmt.Sprintf(test.format, uuid), gc.Equals, test.expect)
+	}
+}
+
+func (*uuidSuite) TestURN(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(uuid.URN(), gc.Equals, "urn:uuid:9f484882-2f18-4fd2-967d-db9663db7bea")
+	parsed, err := utils.UUIDFromString(uuid.URN())
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, uuid)
+}
+
+func (*uuidSuite) TestStringForms(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(uuid.StringCompact(), gc.Equals, "9f4848822f184fd2967ddb9663db7bea")
+	c.Assert(uuid.StringUpper(), gc.Equals, "9F484882-2F18-4FD2-967D-DB9663DB7BEA")
+	for _, s := range []string{uuid.StringCompact(), uuid.StringUpper()} {
+		parsed, err := utils.UUIDFromString(s)
+		c.Assert(err, gc.IsNil)
+		c.Assert(parsed, gc.Equals, uuid)
+	}
+}
+
+func (*uuidSuite) TestShort(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	short := uuid.Short()
+	c.Assert(short, gc.Equals, "Lfo4UasfXACbuNChTnabQu")
+	parsed, err := utils.UUIDFromShort(short)
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, uuid)
+
+	// Leading zero bytes are kept.
+	c.Assert(utils.Nil.Short(), gc.Equals, "1111111111111111")
+	for _, uuid := range []utils.UUID{
+		utils.Nil,
+		{15: 1},
+		{2: 0xff, 15: 0xff},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		c.Logf("uuid %s", uuid)
+		short := uuid.Short()
+		c.Assert(len(short) <= 22, gc.Equals, true)
+		parsed, err := utils.UUIDFromShort(short)
+		c.Assert(err, gc.IsNil)
+		c.Assert(parsed, gc.Equals, uuid)
+	}
+	for i := 0; i < 100; i++ {
+		uuid := utils.MustNewUUID()
+		parsed, err := utils.UUIDFromShort(uuid.Short())
+		c.Assert(err, gc.IsNil)
+		c.Assert(parsed, gc.Equals, uuid)
+	}
+}
+
+func (*uuidSuite) TestUUIDFromShortInvalid(c *gc.C) {
+	for _, s := range []string{
+		"",
+		"Lfo4UasfXACbuNChTnabQ0",
+		"Lfo4UasfXACbuNChTnabQuu",
+		"Lfo4UasfXACbuNChTnab",
+		"1zzzzzzzzzzzzzzzzzzzzz",
+		"111111111111111",
+	} {
+		c.Logf("short %q", s)
+		_, err := utils.UUIDFromShort(s)
+		c.Assert(err, gc.ErrorMatches, `invalid short UUID: ".*"`)
+	}
+}
+
+func (*uuidSuite) TestBase64(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(uuid.Base64(), gc.Equals, "n0hIgi8YT9KWfduWY9t76g")
+	parsed, err := utils.UUIDFromBase64(uuid.Base64())
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, uuid)
+
+	for i := 0; i < 100; i++ {
+		uuid := utils.MustNewUUID()
+		c.Assert(uuid.Base64(), gc.HasLen, 22)
+		parsed, err := utils.UUIDFromBase64(uuid.Base64())
+		c.Assert(err, gc.IsNil)
+		c.Assert(parsed, gc.Equals, uuid)
+	}
+}
+
+func (*uuidSuite) TestUUIDFromBase64Invalid(c *gc.C) {
+	for _, s := range []string{
+		"",
+		"n0hIgi8YT9KWfduWY9t76",
+		"n0hIgi8YT9KWfduWY9t76gg",
+		"n0hIgi8YT9KWfduWY9t76g==",
+		"n0hIgi8YT9KWfduWY9t7+g",
+		"n0hIgi8YT9KWfduWY9t7/g",
+		"n0hIgi8YT9KWfduWY9t76h",
+	} {
+		c.Logf("base64 %q", s)
+		_, err := utils.UUIDFromBase64(s)
+		c.Assert(err, gc.ErrorMatches, `invalid base64 UUID: ".*"`)
+	}
+}
+
+func (*uuidSuite) TestUUIDFromBytes(c *gc.C) {
+	b := []byte("0123456789abcdef")
+	uuid, err := utils.UUIDFromBytes(b)
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid[:], gc.DeepEquals, b)
+	// The bytes aren't changed to make a valid UUID.
+	c.Assert(uuid.Version(), gc.Equals, 3)
+	c.Assert(uuid.Variant(), gc.Equals, utils.VariantNCS)
+
+	_, err = utils.UUIDFromBytes(b[:15])
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: 15 bytes, not 16`)
+	_, err = utils.UUIDFromBytes(nil)
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: 0 bytes, not 16`)
+}
+
+func (*uuidSuite) TestMustUUIDFromString(c *gc.C) {
+	uuid := utils.MustUUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(uuid.String(), gc.Equals, "9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(func() { utils.MustUUIDFromString("blah") }, gc.PanicMatches, `invalid UUID: "blah"`)
+}
+
+func (*uuidSuite) TestParseUUID(c *gc.C) {
+	for _, s := range []string{
+		"00000000-0000-0000-0000-000000000000",
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"5df41881-3aed-3515-88a7-2f4a814cf09e",
+		"2ed6657d-e927-568b-95e1-2665a8aea6a2",
+		"01890a5d-ac96-774b-bcce-b302099a8057",
+		"9f484882-2f18-4fd2-c67d-db9663db7bea",
+	} {
+		c.Logf("uuid %q", s)
+		uuid, err := utils.ParseUUID(s)
+		c.Assert(err, gc.IsNil)
+		c.Assert(uuid.String(), gc.Equals, s)
+	}
+	uuid, err := utils.ParseUUID("2ed6657d-e927-568b-95e1-2665a8aea6a2")
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.Version(), gc.Equals, 5)
+	_, err = utils.ParseUUID("blah")
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+}
+
+func (*uuidSuite) TestUUIDFromStringRejectsOtherVersions(c *gc.C) {
+	for _, s := range []string{
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"5df41881-3aed-3515-88a7-2f4a814cf09e",
+		"2ed6657d-e927-568b-95e1-2665a8aea6a2",
+		"01890a5d-ac96-774b-bcce-b302099a8057",
+		"9f484882-2f18-4fd2-c67d-db9663db7bea",
+		"{2ed6657d-e927-568b-95e1-2665a8aea6a2}",
+		"2ed6657de927568b95e12665a8aea6a2",
+	} {
+		c.Logf("uuid %q", s)
+		_, err := utils.UUIDFromString(s)
+		c.Assert(err, gc.ErrorMatches, `invalid UUID: ".*"`)
+		c.Assert(utils.IsValidUUIDString(s), gc.Equals, false)
+	}
+}
+
+func (*uuidSuite) TestEqualAndCompare(c *gc.C) {
+	a := utils.UUID{0: 1, 15: 1}
+	b := utils.UUID{0: 1, 15: 2}
+	c.Assert(a.Equal(a), gc.Equals, true)
+	c.Assert(a.Equal(b), gc.Equals, false)
+	c.Assert(a.Compare(a), gc.Equals, 0)
+	c.Assert(a.Compare(b), gc.Equals, -1)
+	c.Assert(b.Compare(a), gc.Equals, 1)
+	// The first byte is the most significant.
+	c.Assert(utils.UUID{0: 2}.Compare(b), gc.Equals, 1)
+
+	allocs := stdtesting.AllocsPerRun(100, func() {
+		a.Equal(b)
+		a.Compare(b)
+	})
+	c.Assert(allocs, gc.Equals, 0.0)
+}
+
+func (*uuidSuite) TestSortUUIDs(c *gc.C) {
+	var ids utils.UUIDs
+	for i := 0; i < 100; i++ {
+		uuid, err := utils.NewUUID()
+		c.Assert(err, gc.IsNil)
+		ids = append(ids, uuid)
+	}
+	sort.Sort(ids)
+	for i := 1; i < len(ids); i++ {
+		c.Assert(ids[i-1].Compare(ids[i]), gc.Equals, -1)
+	}
+}
+
+func (*uuidSuite) TestSortUUIDsV7(c *gc.C) {
+	var generated []utils.UUID
+	for i := 0; i < 100; i++ {
+		uuid, err := utils.NewUUIDV7()
+		c.Assert(err, gc.IsNil)
+		generated = append(generated, uuid)
+	}
+	// Sorting version 7 UUIDs puts them back in the order they were
+	// generated.
+	ids := make(utils.UUIDs, len(generated))
+	for i, j := range rand.Perm(len(generated)) {
+		ids[i] = generated[j]
+	}
+	sort.Sort(ids)
+	c.Assert([]utils.UUID(ids), gc.DeepEquals, generated)
+}
+
+func (*uuidSuite) TestNil(c *gc.C) {
+	c.Assert(utils.Nil.IsNil(), gc.Equals, true)
+	c.Assert(utils.UUID{}.IsNil(), gc.Equals, true)
+	c.Assert(utils.Nil.String(), gc.Equals, "00000000-0000-0000-0000-000000000000")
+	c.Assert(utils.Nil.Version(), gc.Equals, 0)
+	uuid, err := utils.UUIDFromString(utils.Nil.String())
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.IsNil(), gc.Equals, true)
+
+	uuid, err = utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid.IsNil(), gc.Equals, false)
+}
+
+func (*uuidSuite) TestJSON(c *gc.C) {
+	type doc struct {
+		ID utils.UUID `json:"id"`
+	}
+	uuid, err := utils.UUIDFromString("9f484882-2f18-4fd2-967d-db9663db7bea")
+	c.Assert(err, gc.IsNil)
+	data, err := json.Marshal(doc{ID: uuid})
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `{"id":"9f484882-2f18-4fd2-967d-db9663db7bea"}`)
+
+	var d doc
+	err = json.Unmarshal(data, &d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.ID, gc.Equals, uuid)
+
+	d.ID = uuid
+	err = json.Unmarshal([]byte(`{"id":null}`), &d)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.ID, gc.Equals, utils.Nil)
+}
+
+func (*uuidSuite) TestUnmarshalJSONInvalid(c *gc.C) {
+	var uuid utils.UUID
+	err := json.Unmarshal([]byte(`"blah"`), &uuid)
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+	err = json.Unmarshal([]byte(`1234`), &uuid)
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: 1234`)
+}
+
+func (*uuidSuite) TestText(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	text, err := uuid.MarshalText()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(text), gc.Equals, uuid.String())
+
+	var parsed utils.UUID
+	err = parsed.UnmarshalText(text)
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, uuid)
+
+	err = parsed.UnmarshalText([]byte(utils.Nil.String()))
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, utils.Nil)
+
+	err = parsed.UnmarshalText([]byte("blah"))
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+}
+
+func (*uuidSuite) TestJSONMapKeys(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	data, err := json.Marshal(map[utils.UUID]utils.UUID{uuid: uuid})
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `{"`+uuid.String()+`":"`+uuid.String()+`"}`)
+
+	var m map[utils.UUID]utils.UUID
+	err = json.Unmarshal(data, &m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m, gc.DeepEquals, map[utils.UUID]utils.UUID{uuid: uuid})
+}
+
+func (*uuidSuite) TestBinary(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	data, err := uuid.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.DeepEquals, uuid[:])
+
+	// The data is a copy.
+	data[0]++
+	c.Assert(data[0], gc.Not(gc.Equals), uuid[0])
+	data[0]--
+
+	var parsed utils.UUID
+	err = parsed.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(parsed, gc.Equals, uuid)
+}
+
+func (*uuidSuite) TestUnmarshalBinaryWrongLength(c *gc.C) {
+	var uuid utils.UUID
+	err := uuid.UnmarshalBinary(make([]byte, 15))
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: 15 bytes, not 16`)
+	err = uuid.UnmarshalBinary(make([]byte, 17))
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: 17 bytes, not 16`)
+}
+
+func (*uuidSuite) TestGob(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(uuid)
+	c.Assert(err, gc.IsNil)
+	var decoded utils.UUID
+	err = gob.NewDecoder(&buf).Decode(&decoded)
+	c.Assert(err, gc.IsNil)
+	c.Assert(decoded, gc.Equals, uuid)
+}
+
+func (*uuidSuite) TestValue(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	value, err := uuid.Value()
+	c.Assert(err, gc.IsNil)
+	c.Assert(value, gc.Equals, uuid.String())
+}
+
+func (*uuidSuite) TestScan(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	for i, src := range []interface{}{
+		uuid.String(),
+		[]byte(uuid.String()),
+		uuid[:],
+	} {
+		c.Logf("test %d: %#v", i, src)
+		var scanned utils.UUID
+		err := scanned.Scan(src)
+		c.Assert(err, gc.IsNil)
+		c.Assert(scanned, gc.Equals, uuid)
+	}
+
+	scanned := uuid
+	err = scanned.Scan(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(scanned, gc.Equals, utils.Nil)
+}
+
+func (*uuidSuite) TestScanInvalid(c *gc.C) {
+	var uuid utils.UUID
+	err := uuid.Scan("blah")
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+	err = uuid.Scan([]byte("blah"))
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+	err = uuid.Scan(int64(1))
+	c.Assert(err, gc.ErrorMatches, `cannot scan int64 into UUID`)
+}
+
+func (*uuidSuite) TestNullUUIDSQL(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+
+	var n utils.NullUUID
+	err = n.Scan(uuid.String())
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, utils.NullUUID{UUID: uuid, Valid: true})
+	value, err := n.Value()
+	c.Assert(err, gc.IsNil)
+	c.Assert(value, gc.Equals, uuid.String())
+
+	err = n.Scan(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, utils.NullUUID{})
+	value, err = n.Value()
+	c.Assert(err, gc.IsNil)
+	c.Assert(value, gc.IsNil)
+
+	err = n.Scan("blah")
+	c.Assert(err, gc.ErrorMatches, `invalid UUID: "blah"`)
+}
+
+func (*uuidSuite) TestNullUUIDJSON(c *gc.C) {
+	uuid, err := utils.NewUUID()
+	c.Assert(err, gc.IsNil)
+	data, err := json.Marshal([]utils.NullUUID{{UUID: uuid, Valid: true}, {}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, `["`+uuid.String()+`",null]`)
+
+	var decoded []utils.NullUUID
+	err = json.Unmarshal(data, &decoded)
+	c.Assert(err, gc.IsNil)
+	c.Assert(decoded, gc.DeepEquals, []utils.NullUUID{{UUID: uuid, Valid: true}, {}})
+}
+
+func BenchmarkNewUUID(b *stdtesting.B) {
+	// Generate 1000 UUIDs each time, to compare with NewUUIDs.
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			if _, err := utils.NewUUID(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkNewUUIDs(b *stdtesting.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := utils.NewUUIDs(1000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewUUIDParallel(b *stdtesting.B) {
+	b.RunParallel(func(pb *stdtesting.PB) {
+		for pb.Next() {
+			if _, err := utils.NewUUID(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkNewUUIDFromReaderParallel(b *stdtesting.B) {
+	// This is how NewUUID used to work, reading from crypto/rand for
+	// every UUID.
+	b.RunParallel(func(pb *stdtesting.PB) {
+		for pb.Next() {
+			if _, err := utils.NewUUIDFromReader(cryptorand.Reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkString(b *stdtesting.B) {
+	uuid := utils.MustNewUUID()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = uuid.String()
+	}
+}
+
+func BenchmarkAppendString(b *stdtesting.B) {
+	uuid := utils.MustNewUUID()
+	buf := make([]byte, 0, 36)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = uuid.AppendString(buf[:0])
+	}
+}